@@ -0,0 +1,189 @@
+package wasm3
+
+import(
+	"errors"
+	"fmt"
+)
+
+// GuestPtr is a pointer into the guest memory
+type GuestPtr = uint32
+
+var(
+	// ErrAllocationFailed is returned when the guest allocator returns a NULL pointer
+	ErrAllocationFailed = errors.New("Guest allocation failed")
+
+	errNoAllocator = errors.New("No guest allocator, see UseAllocator")
+)
+
+// allocator holds the guest functions used to allocate and free memory
+type allocator struct {
+	alloc *Function
+	free *Function
+	// freeWithSize is set when free takes the size of the allocation, e.g. Rust dealloc functions
+	freeWithSize bool
+	sizes map[GuestPtr]uint32
+}
+
+// UseAllocator sets the guest functions used by Alloc, AllocBytes, AllocString and Free.
+// The allocation function must have the signature i(i) (size to pointer) and the free function
+// v(i) (pointer) or v(ii) (pointer and size). freeName may be empty for guests that don't free memory,
+// Free does nothing in that case.
+func(r *Runtime) UseAllocator(allocName, freeName string) error {
+	alloc, err := r.FindFunction(allocName)
+	if err != nil {
+		return err
+	}
+	if sig := alloc.Signature().String(); sig != "i(i)" {
+		return fmt.Errorf("Allocation function %s must have the signature i(i), got %s", allocName, sig)
+	}
+	a := &allocator{
+		alloc: alloc,
+		sizes: make(map[GuestPtr]uint32),
+	}
+	if freeName != "" {
+		a.free, err = r.FindFunction(freeName)
+		if err != nil {
+			return err
+		}
+		switch sig := a.free.Signature().String(); sig {
+		case "v(i)":
+		case "v(ii)":
+			a.freeWithSize = true
+		default:
+			return fmt.Errorf("Free function %s must have the signature v(i) or v(ii), got %s", freeName, sig)
+		}
+	}
+	r.allocator = a
+	return nil
+}
+
+// Alloc allocates size bytes in the guest memory
+func(r *Runtime) Alloc(size uint32) (GuestPtr, error) {
+	if r.allocator == nil {
+		return 0, errNoAllocator
+	}
+	result, err := r.allocator.alloc.Call(size)
+	if err != nil {
+		return 0, err
+	}
+	ptr := GuestPtr(result.(int32))
+	if ptr == 0 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrAllocationFailed, size)
+	}
+	if r.allocator.freeWithSize {
+		r.allocator.sizes[ptr] = size
+	}
+	return ptr, nil
+}
+
+// AllocBytes allocates a copy of data in the guest memory
+func(r *Runtime) AllocBytes(data []byte) (GuestPtr, error) {
+	ptr, err := r.Alloc(uint32(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	if err := r.Memory().Write(ptr, data); err != nil {
+		r.Free(ptr)
+		return 0, err
+	}
+	return ptr, nil
+}
+
+// AllocString allocates a copy of s in the guest memory, it's NUL-terminated
+// so it can be passed as a C string or along with len(s).
+func(r *Runtime) AllocString(s string) (GuestPtr, error) {
+	ptr, err := r.Alloc(uint32(len(s) + 1))
+	if err != nil {
+		return 0, err
+	}
+	mem := r.Memory()
+	mem.MaxStringLength = uint32(len(s))
+	if err := mem.WriteString(ptr, s); err == nil {
+		err = mem.WriteUint8(ptr + uint32(len(s)), 0)
+	}
+	if err != nil {
+		r.Free(ptr)
+		return 0, err
+	}
+	return ptr, nil
+}
+
+// Free releases memory allocated with Alloc, AllocBytes or AllocString
+func(r *Runtime) Free(ptr GuestPtr) error {
+	a := r.allocator
+	if a == nil {
+		return errNoAllocator
+	}
+	if a.free == nil {
+		return nil
+	}
+	if !a.freeWithSize {
+		_, err := a.free.Call(ptr)
+		return err
+	}
+	size, ok := a.sizes[ptr]
+	if !ok {
+		return fmt.Errorf("Pointer %d wasn't allocated with Alloc", ptr)
+	}
+	delete(a.sizes, ptr)
+	_, err := a.free.Call(ptr, size)
+	return err
+}
+
+// Forget stops tracking ptr, it's used when the guest takes ownership of the memory and frees it
+func(r *Runtime) Forget(ptr GuestPtr) {
+	if r.allocator != nil {
+		delete(r.allocator.sizes, ptr)
+	}
+}
+
+// Arena tracks guest allocations so they can be freed at once, e.g. after a call:
+//
+//	arena := runtime.NewArena()
+//	defer arena.Free()
+//	ptr, err := arena.AllocString(input)
+type Arena struct {
+	runtime *Runtime
+	ptrs []GuestPtr
+}
+
+// NewArena returns an arena that allocates with the runtime allocator
+func(r *Runtime) NewArena() *Arena {
+	return &Arena{
+		runtime: r,
+	}
+}
+
+func(a *Arena) track(ptr GuestPtr, err error) (GuestPtr, error) {
+	if err == nil {
+		a.ptrs = append(a.ptrs, ptr)
+	}
+	return ptr, err
+}
+
+// Alloc works like Runtime.Alloc
+func(a *Arena) Alloc(size uint32) (GuestPtr, error) {
+	return a.track(a.runtime.Alloc(size))
+}
+
+// AllocBytes works like Runtime.AllocBytes
+func(a *Arena) AllocBytes(data []byte) (GuestPtr, error) {
+	return a.track(a.runtime.AllocBytes(data))
+}
+
+// AllocString works like Runtime.AllocString
+func(a *Arena) AllocString(s string) (GuestPtr, error) {
+	return a.track(a.runtime.AllocString(s))
+}
+
+// Free releases all the arena allocations, it returns the first error
+func(a *Arena) Free() error {
+	var firstErr error
+	for _, ptr := range a.ptrs {
+		if err := a.runtime.Free(ptr); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	a.ptrs = nil
+	return firstErr
+}
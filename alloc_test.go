@@ -0,0 +1,127 @@
+package wasm3
+
+import (
+	"errors"
+	"testing"
+)
+
+// allocTestModule has a bump allocator starting at 1024 and a free function
+// that counts the calls and the released bytes.
+func allocTestModule() *testModule {
+	m := &testModule{memory: &testMemory{min: 2}}
+	heap := byte(m.addGlobal(testGlobal{typ: i32, mutable: true, init: append([]byte{0x41}, sleb(1024)...)}))
+	freed := byte(m.addGlobal(testGlobal{typ: i32, mutable: true, init: []byte{0x41, 0}}))
+	freedBytes := byte(m.addGlobal(testGlobal{typ: i32, mutable: true, init: []byte{0x41, 0}}))
+	// alloc returns NULL for allocations above 64 KiB
+	alloc := append([]byte{0x20, 0, 0x41}, sleb(65536)...)
+	alloc = append(alloc, 0x4b, 0x04, i32, 0x41, 0, 0x05,
+		0x23, heap, 0x23, heap, 0x20, 0, 0x6a, 0x24, heap, 0x0b)
+	m.addFunc(testFunc{export: "alloc", params: []byte{i32}, results: []byte{i32}, code: alloc})
+	m.addFunc(testFunc{export: "free", params: []byte{i32, i32},
+		code: []byte{0x23, freed, 0x41, 1, 0x6a, 0x24, freed, 0x23, freedBytes, 0x20, 1, 0x6a, 0x24, freedBytes}})
+	m.addFunc(testFunc{export: "free_ptr", params: []byte{i32},
+		code: []byte{0x23, freed, 0x41, 1, 0x6a, 0x24, freed}})
+	m.addFunc(testFunc{export: "freed", results: []byte{i32}, code: []byte{0x23, freed}})
+	m.addFunc(testFunc{export: "freed_bytes", results: []byte{i32}, code: []byte{0x23, freedBytes}})
+	return m
+}
+
+func TestAllocator(t *testing.T) {
+	runtime := loadTestModule(t, allocTestModule())
+	defer runtime.Destroy()
+
+	if _, err := runtime.AllocBytes([]byte("data")); err != errNoAllocator {
+		t.Fatalf("Expected errNoAllocator, got %v", err)
+	}
+	if err := runtime.UseAllocator("freed", "free"); err == nil {
+		t.Fatal("An allocation function with the wrong signature should error")
+	}
+	if err := runtime.UseAllocator("alloc", "freed"); err == nil {
+		t.Fatal("A free function with the wrong signature should error")
+	}
+	if err := runtime.UseAllocator("alloc", "missing"); err == nil {
+		t.Fatal("A missing free function should error")
+	}
+	if err := runtime.UseAllocator("alloc", "free"); err != nil {
+		t.Fatal(err)
+	}
+
+	ptr, err := runtime.AllocBytes([]byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptr != 1024 {
+		t.Fatalf("Unexpected pointer: %d", ptr)
+	}
+	if data, err := runtime.Memory().Read(ptr, 3); err != nil || data[0] != 1 || data[2] != 3 {
+		t.Fatalf("Unexpected data: %v, %v", data, err)
+	}
+	str, err := runtime.AllocString("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := runtime.Memory().ReadCString(str); err != nil || s != "hello" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if err := runtime.Free(ptr); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.Free(ptr); err == nil {
+		t.Fatal("Freeing a pointer twice should error when the size is needed")
+	}
+	if err := runtime.Free(str); err != nil {
+		t.Fatal(err)
+	}
+	if n := callFunction(t, runtime, "freed"); n != int32(2) {
+		t.Fatalf("Expected 2 calls to free, got %v", n)
+	}
+	if n := callFunction(t, runtime, "freed_bytes"); n != int32(9) {
+		t.Fatalf("Expected 9 freed bytes, got %v", n)
+	}
+	owned, err := runtime.Alloc(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.Forget(owned)
+	if err := runtime.Free(owned); err == nil {
+		t.Fatal("Freeing a forgotten pointer should error when the size is needed")
+	}
+	if _, err := runtime.Alloc(1 << 20); !errors.Is(err, ErrAllocationFailed) {
+		t.Fatalf("Expected ErrAllocationFailed, got %v", err)
+	}
+}
+
+func TestArena(t *testing.T) {
+	runtime := loadTestModule(t, allocTestModule())
+	defer runtime.Destroy()
+	if err := runtime.UseAllocator("alloc", "free_ptr"); err != nil {
+		t.Fatal(err)
+	}
+
+	arena := runtime.NewArena()
+	if _, err := arena.AllocString("input"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := arena.AllocBytes(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := arena.Alloc(1 << 20); err == nil {
+		t.Fatal("The allocation should fail")
+	}
+	if n := callFunction(t, runtime, "freed"); n != int32(0) {
+		t.Fatalf("Nothing should be freed yet, got %v", n)
+	}
+	if err := arena.Free(); err != nil {
+		t.Fatal(err)
+	}
+	if n := callFunction(t, runtime, "freed"); n != int32(2) {
+		t.Fatalf("Expected 2 calls to free, got %v", n)
+	}
+	// The arena can be reused
+	if err := arena.Free(); err != nil {
+		t.Fatal(err)
+	}
+	if n := callFunction(t, runtime, "freed"); n != int32(2) {
+		t.Fatalf("Expected 2 calls to free, got %v", n)
+	}
+}
@@ -0,0 +1,148 @@
+package wasm3
+
+import(
+	"errors"
+)
+
+var(
+	errBinaryUnderrun = errors.New("Unexpected end of WASM binary")
+)
+
+// wasmImport is an entry of the import section
+type wasmImport struct {
+	module string
+	field string
+	kind ExternKind
+}
+
+// wasmExport is an entry of the export section
+type wasmExport struct {
+	name string
+	kind ExternKind
+	index uint32
+}
+
+// wasmSections holds the parts of the WASM binary that WASM3 doesn't keep after parsing
+type wasmSections struct {
+	imports []wasmImport
+	exports []wasmExport
+}
+
+// binaryReader decodes the WASM binary format
+type binaryReader struct {
+	buf []byte
+	err error
+}
+
+func(r *binaryReader) byte() byte {
+	if r.err != nil || len(r.buf) == 0 {
+		r.err = errBinaryUnderrun
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func(r *binaryReader) bytes(n uint64) []byte {
+	if r.err != nil || uint64(len(r.buf)) < n {
+		r.err = errBinaryUnderrun
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func(r *binaryReader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b := r.byte()
+		v |= uint64(b & 0x7f) << shift
+		if b & 0x80 == 0 {
+			return v
+		}
+	}
+	if r.err == nil {
+		r.err = errors.New("LEB encoded value overflow")
+	}
+	return 0
+}
+
+func(r *binaryReader) name() string {
+	return string(r.bytes(r.uleb()))
+}
+
+// limits skips the limits of a table or memory type
+func(r *binaryReader) limits() {
+	if r.byte() & 1 != 0 {
+		r.uleb()
+	}
+	r.uleb()
+}
+
+// parseSections reads the import and export sections of a WASM binary,
+// the rest of the module is validated by WASM3.
+func parseSections(wasm []byte) (*wasmSections, error) {
+	r := &binaryReader{buf: wasm}
+	r.bytes(8)
+	s := &wasmSections{}
+	for r.err == nil && len(r.buf) > 0 {
+		id := r.byte()
+		section := &binaryReader{buf: r.bytes(r.uleb())}
+		if r.err != nil {
+			break
+		}
+		switch id {
+		case 2:
+			s.imports = section.imports()
+		case 7:
+			s.exports = section.exports()
+		}
+		if section.err != nil {
+			return nil, section.err
+		}
+	}
+	return s, r.err
+}
+
+func(r *binaryReader) imports() []wasmImport {
+	count := r.uleb()
+	var imports []wasmImport
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		imp := wasmImport{
+			module: r.name(),
+			field: r.name(),
+			kind: ExternKind(r.byte()),
+		}
+		switch imp.kind {
+		case ExternFunction:
+			r.uleb()
+		case ExternTable:
+			r.byte()
+			r.limits()
+		case ExternMemory:
+			r.limits()
+		case ExternGlobal:
+			r.byte()
+			r.byte()
+		default:
+			r.err = errors.New("Invalid import kind")
+		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
+
+func(r *binaryReader) exports() []wasmExport {
+	count := r.uleb()
+	var exports []wasmExport
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		exports = append(exports, wasmExport{
+			name: r.name(),
+			kind: ExternKind(r.byte()),
+			index: uint32(r.uleb()),
+		})
+	}
+	return exports
+}
@@ -0,0 +1,198 @@
+package wasm3
+
+// This file contains a tiny WASM binary encoder, it's only used to build
+// the test modules without depending on external toolchains.
+
+const (
+	i32 byte = 0x7f
+	i64 byte = 0x7e
+	f32 byte = 0x7d
+	f64 byte = 0x7c
+)
+
+type testFunc struct {
+	params  []byte
+	results []byte
+	locals  []byte
+	code    []byte
+	export  string
+}
+
+// testImport is a function import unless global or memory are set
+type testImport struct {
+	module  string
+	field   string
+	params  []byte
+	results []byte
+	global  *testGlobal
+	memory  *testMemory
+}
+
+// testGlobal is a global definition, init is a constant expression without the end opcode
+type testGlobal struct {
+	typ     byte
+	mutable bool
+	init    []byte
+	export  string
+}
+
+type testMemory struct {
+	min    uint32
+	max    uint32
+	hasMax bool
+}
+
+type testModule struct {
+	imports []testImport
+	funcs   []testFunc
+	globals []testGlobal
+	memory  *testMemory
+}
+
+// numImports returns the number of imports of the same kind as imp
+func (m *testModule) numImports(imp testImport) int {
+	n := 0
+	for _, i := range m.imports {
+		if (i.global == nil) == (imp.global == nil) && (i.memory == nil) == (imp.memory == nil) {
+			n++
+		}
+	}
+	return n
+}
+
+// addImport adds an import and returns its index, imports must be added before the definitions.
+func (m *testModule) addImport(imp testImport) uint32 {
+	index := m.numImports(imp)
+	m.imports = append(m.imports, imp)
+	return uint32(index)
+}
+
+func (m *testModule) addFunc(f testFunc) uint32 {
+	m.funcs = append(m.funcs, f)
+	return uint32(m.numImports(testImport{}) + len(m.funcs) - 1)
+}
+
+func (m *testModule) addGlobal(g testGlobal) uint32 {
+	m.globals = append(m.globals, g)
+	return uint32(m.numImports(testImport{global: &g}) + len(m.globals) - 1)
+}
+
+func limits(l *testMemory) []byte {
+	if l.hasMax {
+		return append(append([]byte{0x01}, uleb(uint64(l.min))...), uleb(uint64(l.max))...)
+	}
+	return append([]byte{0x00}, uleb(uint64(l.min))...)
+}
+
+func globalType(g *testGlobal) []byte {
+	if g.mutable {
+		return []byte{g.typ, 0x01}
+	}
+	return []byte{g.typ, 0x00}
+}
+
+func funcType(params, results []byte) []byte {
+	t := append([]byte{0x60}, uleb(uint64(len(params)))...)
+	t = append(t, params...)
+	t = append(t, uleb(uint64(len(results)))...)
+	return append(t, results...)
+}
+
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func name(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
+
+func vec(items ...[]byte) []byte {
+	out := uleb(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func section(id byte, items [][]byte) []byte {
+	if len(items) == 0 {
+		return nil
+	}
+	body := vec(items...)
+	out := append([]byte{id}, uleb(uint64(len(body)))...)
+	return append(out, body...)
+}
+
+func (m *testModule) bytes() []byte {
+	var types, imports, funcs, memories, globals, exports, codes [][]byte
+	for _, imp := range m.imports {
+		entry := append(name(imp.module), name(imp.field)...)
+		switch {
+		case imp.global != nil:
+			entry = append(append(entry, 0x03), globalType(imp.global)...)
+		case imp.memory != nil:
+			entry = append(append(entry, 0x02), limits(imp.memory)...)
+		default:
+			entry = append(append(entry, 0x00), uleb(uint64(len(types)))...)
+			types = append(types, funcType(imp.params, imp.results))
+		}
+		imports = append(imports, entry)
+	}
+	numFuncImports := m.numImports(testImport{})
+	for i, f := range m.funcs {
+		funcs = append(funcs, uleb(uint64(len(types))))
+		types = append(types, funcType(f.params, f.results))
+		if f.export != "" {
+			idx := uint64(numFuncImports + i)
+			exports = append(exports, append(name(f.export), append([]byte{0x00}, uleb(idx)...)...))
+		}
+		var locals [][]byte
+		for _, l := range f.locals {
+			locals = append(locals, []byte{0x01, l})
+		}
+		body := append(vec(locals...), f.code...)
+		body = append(body, 0x0b)
+		codes = append(codes, append(uleb(uint64(len(body))), body...))
+	}
+	for i, g := range m.globals {
+		globals = append(globals, append(append(globalType(&g), g.init...), 0x0b))
+		if g.export != "" {
+			idx := uint64(m.numImports(testImport{global: &g}) + i)
+			exports = append(exports, append(name(g.export), append([]byte{0x03}, uleb(idx)...)...))
+		}
+	}
+	if m.memory != nil {
+		memories = append(memories, limits(m.memory))
+		exports = append(exports, append(name("memory"), 0x02, 0x00))
+	}
+	out := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	out = append(out, section(1, types)...)
+	out = append(out, section(2, imports)...)
+	out = append(out, section(3, funcs)...)
+	out = append(out, section(5, memories)...)
+	out = append(out, section(6, globals)...)
+	out = append(out, section(7, exports)...)
+	out = append(out, section(10, codes)...)
+	return out
+}
@@ -5,16 +5,295 @@ package wasm3
 */
 import "C"
 
+import(
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Sentinel errors for the WASM3 result constants (m3Err_* in m3.h).
+// Errors returned by this package wrap them in a *TrapError, *CompileError or *LinkError,
+// use errors.Is to check for a specific condition and errors.As to check the class.
 var(
-	lastError string
+	// General errors
+	ErrTypeListOverflow = m3Error(C.m3Err_typeListOverflow)
+	ErrMallocFailed = m3Error(C.m3Err_mallocFailed)
+
+	// Parse errors
+	ErrIncompatibleWasmVersion = m3Error(C.m3Err_incompatibleWasmVersion)
+	ErrWasmMalformed = m3Error(C.m3Err_wasmMalformed)
+	ErrMisorderedWasmSection = m3Error(C.m3Err_misorderedWasmSection)
+	ErrWasmUnderrun = m3Error(C.m3Err_wasmUnderrun)
+	ErrWasmOverrun = m3Error(C.m3Err_wasmOverrun)
+	ErrWasmMissingInitExpr = m3Error(C.m3Err_wasmMissingInitExpr)
+	ErrLEBOverflow = m3Error(C.m3Err_lebOverflow)
+	ErrMissingUTF8 = m3Error(C.m3Err_missingUTF8)
+	ErrWasmSectionUnderrun = m3Error(C.m3Err_wasmSectionUnderrun)
+	ErrWasmSectionOverrun = m3Error(C.m3Err_wasmSectionOverrun)
+	ErrInvalidTypeID = m3Error(C.m3Err_invalidTypeId)
+	ErrTooManyMemorySections = m3Error(C.m3Err_tooManyMemorySections)
+
+	// Link errors
+	ErrModuleAlreadyLinked = m3Error(C.m3Err_moduleAlreadyLinked)
+	ErrFunctionLookupFailed = m3Error(C.m3Err_functionLookupFailed)
+	ErrFunctionImportMissing = m3Error(C.m3Err_functionImportMissing)
+
+	// Compilation errors
+	ErrNoCompiler = m3Error(C.m3Err_noCompiler)
+	ErrUnknownOpcode = m3Error(C.m3Err_unknownOpcode)
+	ErrFunctionStackOverflow = m3Error(C.m3Err_functionStackOverflow)
+	ErrFunctionStackUnderrun = m3Error(C.m3Err_functionStackUnderrun)
+	ErrMallocFailedCodePage = m3Error(C.m3Err_mallocFailedCodePage)
+	ErrSettingImmutableGlobal = m3Error(C.m3Err_settingImmutableGlobal)
+	ErrOptimizerFailed = m3Error(C.m3Err_optimizerFailed)
+
+	// Runtime errors
+	ErrMissingCompiledCode = m3Error(C.m3Err_missingCompiledCode)
+	ErrWasmMemoryOverflow = m3Error(C.m3Err_wasmMemoryOverflow)
+	ErrGlobalMemoryNotAllocated = m3Error(C.m3Err_globalMemoryNotAllocated)
+	ErrGlobalIndexOutOfBounds = m3Error(C.m3Err_globaIndexOutOfBounds)
+
+	// Traps
+	ErrTrapOutOfBoundsMemoryAccess = m3Error(C.m3Err_trapOutOfBoundsMemoryAccess)
+	ErrTrapDivisionByZero = m3Error(C.m3Err_trapDivisionByZero)
+	ErrTrapIntegerOverflow = m3Error(C.m3Err_trapIntegerOverflow)
+	ErrTrapIntegerConversion = m3Error(C.m3Err_trapIntegerConversion)
+	ErrTrapIndirectCallTypeMismatch = m3Error(C.m3Err_trapIndirectCallTypeMismatch)
+	ErrTrapTableIndexOutOfRange = m3Error(C.m3Err_trapTableIndexOutOfRange)
+	ErrTrapExit = m3Error(C.m3Err_trapExit)
+	ErrTrapAbort = m3Error(C.m3Err_trapAbort)
+	ErrTrapUnreachable = m3Error(C.m3Err_trapUnreachable)
+	ErrTrapStackOverflow = m3Error(C.m3Err_trapStackOverflow)
+)
+
+// errorKind is the class of a WASM3 result
+type errorKind int
+
+const(
+	// kindAny is used for the results that can show up in any phase,
+	// the class is decided by the caller
+	kindAny errorKind = iota
+	kindCompile
+	kindLink
+	kindTrap
 )
 
-//export set_error
-func set_error(str ResultT) {
-	lastError = C.GoString(str)
+type m3ErrorInfo struct {
+	err error
+	kind errorKind
+}
+
+// m3Errors maps the WASM3 result constants to their sentinel errors
+var m3Errors = map[C.M3Result]m3ErrorInfo{
+	C.m3Err_typeListOverflow: {ErrTypeListOverflow, kindCompile},
+	C.m3Err_mallocFailed: {ErrMallocFailed, kindAny},
+
+	C.m3Err_incompatibleWasmVersion: {ErrIncompatibleWasmVersion, kindCompile},
+	C.m3Err_wasmMalformed: {ErrWasmMalformed, kindCompile},
+	C.m3Err_misorderedWasmSection: {ErrMisorderedWasmSection, kindCompile},
+	C.m3Err_wasmUnderrun: {ErrWasmUnderrun, kindCompile},
+	C.m3Err_wasmOverrun: {ErrWasmOverrun, kindCompile},
+	C.m3Err_wasmMissingInitExpr: {ErrWasmMissingInitExpr, kindCompile},
+	C.m3Err_lebOverflow: {ErrLEBOverflow, kindCompile},
+	C.m3Err_missingUTF8: {ErrMissingUTF8, kindCompile},
+	C.m3Err_wasmSectionUnderrun: {ErrWasmSectionUnderrun, kindCompile},
+	C.m3Err_wasmSectionOverrun: {ErrWasmSectionOverrun, kindCompile},
+	C.m3Err_invalidTypeId: {ErrInvalidTypeID, kindCompile},
+	C.m3Err_tooManyMemorySections: {ErrTooManyMemorySections, kindCompile},
+
+	C.m3Err_moduleAlreadyLinked: {ErrModuleAlreadyLinked, kindLink},
+	C.m3Err_functionLookupFailed: {ErrFunctionLookupFailed, kindLink},
+	C.m3Err_functionImportMissing: {ErrFunctionImportMissing, kindLink},
+
+	C.m3Err_noCompiler: {ErrNoCompiler, kindCompile},
+	C.m3Err_unknownOpcode: {ErrUnknownOpcode, kindCompile},
+	C.m3Err_functionStackOverflow: {ErrFunctionStackOverflow, kindCompile},
+	C.m3Err_functionStackUnderrun: {ErrFunctionStackUnderrun, kindCompile},
+	C.m3Err_mallocFailedCodePage: {ErrMallocFailedCodePage, kindCompile},
+	C.m3Err_settingImmutableGlobal: {ErrSettingImmutableGlobal, kindCompile},
+	C.m3Err_optimizerFailed: {ErrOptimizerFailed, kindCompile},
+
+	C.m3Err_missingCompiledCode: {ErrMissingCompiledCode, kindAny},
+	C.m3Err_wasmMemoryOverflow: {ErrWasmMemoryOverflow, kindAny},
+	C.m3Err_globalMemoryNotAllocated: {ErrGlobalMemoryNotAllocated, kindAny},
+	C.m3Err_globaIndexOutOfBounds: {ErrGlobalIndexOutOfBounds, kindAny},
+
+	C.m3Err_trapOutOfBoundsMemoryAccess: {ErrTrapOutOfBoundsMemoryAccess, kindTrap},
+	C.m3Err_trapDivisionByZero: {ErrTrapDivisionByZero, kindTrap},
+	C.m3Err_trapIntegerOverflow: {ErrTrapIntegerOverflow, kindTrap},
+	C.m3Err_trapIntegerConversion: {ErrTrapIntegerConversion, kindTrap},
+	C.m3Err_trapIndirectCallTypeMismatch: {ErrTrapIndirectCallTypeMismatch, kindTrap},
+	C.m3Err_trapTableIndexOutOfRange: {ErrTrapTableIndexOutOfRange, kindTrap},
+	C.m3Err_trapExit: {ErrTrapExit, kindTrap},
+	C.m3Err_trapAbort: {ErrTrapAbort, kindTrap},
+	C.m3Err_trapUnreachable: {ErrTrapUnreachable, kindTrap},
+	C.m3Err_trapStackOverflow: {ErrTrapStackOverflow, kindTrap},
+
+	C.goErr_interrupted: {ErrInterrupted, kindTrap},
+	C.goErr_outOfFuel: {ErrOutOfFuel, kindTrap},
+}
+
+func m3Error(result C.M3Result) error {
+	return errors.New(C.GoString(result))
+}
+
+// TrapError is returned when the guest traps during a call
+type TrapError struct {
+	Err error
+}
+
+func(e *TrapError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the sentinel error
+func(e *TrapError) Unwrap() error {
+	return e.Err
+}
+
+// CompileError is returned when a module can't be parsed, loaded or compiled
+type CompileError struct {
+	Err error
+}
+
+func(e *CompileError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the sentinel error
+func(e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// LinkError is returned when imports or functions can't be resolved
+type LinkError struct {
+	Err error
+	// Missing lists the unresolved imports when using Config.StrictImports
+	Missing []Import
+}
+
+func(e *LinkError) Error() string {
+	if len(e.Missing) == 0 {
+		return e.Err.Error()
+	}
+	names := make([]string, len(e.Missing))
+	for i, imp := range e.Missing {
+		names[i] = imp.String()
+	}
+	return e.Err.Error() + ": " + strings.Join(names, ", ")
+}
+
+// Unwrap returns the sentinel error
+func(e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// newError wraps a WASM3 result into a typed error, kind is used for the results
+// that don't belong to a specific class (e.g. allocation failures).
+// Results that aren't m3Err_* constants keep their message.
+func newError(result C.M3Result, kind errorKind) error {
+	if result == nil {
+		return nil
+	}
+	info, ok := m3Errors[result]
+	if !ok {
+		info.err = errors.New(C.GoString(result))
+		if strings.HasPrefix(C.GoString(result), "[trap]") {
+			info.kind = kindTrap
+		}
+	}
+	if info.kind == kindAny {
+		info.kind = kind
+	}
+	switch info.kind {
+	case kindCompile:
+		return &CompileError{Err: info.err}
+	case kindLink:
+		return &LinkError{Err: info.err}
+	}
+	return &TrapError{Err: info.err}
+}
+
+// Error holds the details WASM3 reports along with an error (see M3ErrorInfo).
+// Err is the *TrapError, *CompileError or *LinkError.
+type Error struct {
+	Err error
+	// Module is the name of the module that failed
+	Module string
+	// Function is the function that failed, WASM3 doesn't track the guest function
+	// that traps so it's usually the function that was called
+	Function string
+	// File and Line point to the WASM3 source that reported the error
+	File string
+	Line int
+	// Message is the formatted message reported by WASM3, it may be empty
+	Message string
+}
+
+func(e *Error) Error() string {
+	msg := e.Err.Error()
+	if e.Function != "" {
+		msg += " in " + e.Function
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Unwrap returns the typed error
+func(e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError wraps a WASM3 result with the runtime error info, the info is reset afterwards.
+// module and function are used when WASM3 doesn't report them.
+func(r *Runtime) newError(result C.M3Result, kind errorKind, module C.IM3Module, function C.IM3Function) error {
+	if result == nil {
+		return nil
+	}
+	// m3_GetErrorInfo clears the message buffer, so the info is read before resetting it
+	info := r.Ptr().error
+	defer C.m3_ResetErrorInfo(r.Ptr())
+	e := &Error{
+		Err: newError(result, kind),
+	}
+	if info.result == result {
+		if info.module != nil {
+			module = info.module
+		}
+		if info.function != nil {
+			function = info.function
+		}
+		if info.file != nil {
+			e.File = C.GoString(info.file)
+			e.Line = int(info.line)
+		}
+		if info.message != nil {
+			e.Message = C.GoString(info.message)
+		}
+	}
+	if module == nil && function != nil {
+		module = function.module
+	}
+	if module != nil && module.name != nil {
+		e.Module = C.GoString(module.name)
+	}
+	if function != nil {
+		e.Function = functionName(function)
+	}
+	return e
 }
 
-// LastErrorString returns the last runtime error
-func LastErrorString() string {
-	return lastError
+// functionName returns the function name, or its index for functions without a name
+func functionName(f C.IM3Function) string {
+	if f.name != nil {
+		return C.GoString(f.name)
+	}
+	if f.module == nil {
+		return ""
+	}
+	index := (uintptr(unsafe.Pointer(f)) - uintptr(unsafe.Pointer(f.module.functions))) / C.sizeof_M3Function
+	return fmt.Sprintf("func[%d]", index)
 }
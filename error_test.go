@@ -0,0 +1,171 @@
+package wasm3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func trapTestModule() *testModule {
+	m := &testModule{memory: &testMemory{min: 1}}
+	missing := m.addImport(testImport{module: "env", field: "missing", results: []byte{i32}})
+	m.addFunc(testFunc{export: "unreachable", code: []byte{0x00}})
+	m.addFunc(testFunc{export: "div", params: []byte{i32, i32}, results: []byte{i32},
+		code: []byte{0x20, 0, 0x20, 1, 0x6d}})
+	m.addFunc(testFunc{export: "load", params: []byte{i32}, results: []byte{i32},
+		code: []byte{0x20, 0, 0x28, 0x02, 0x00}})
+	m.addFunc(testFunc{export: "call_missing", results: []byte{i32},
+		code: []byte{0x10, byte(missing)}})
+	recurse := uint32(len(m.imports) + len(m.funcs))
+	m.addFunc(testFunc{export: "recurse", code: []byte{0x10, byte(recurse)}})
+	return m
+}
+
+func TestTrapErrors(t *testing.T) {
+	runtime := loadTestModule(t, trapTestModule())
+	defer runtime.Destroy()
+
+	cases := []struct {
+		name     string
+		args     []interface{}
+		sentinel error
+	}{
+		{"unreachable", nil, ErrTrapUnreachable},
+		{"div", []interface{}{1, 0}, ErrTrapDivisionByZero},
+		{"div", []interface{}{int32(-1 << 31), -1}, ErrTrapIntegerOverflow},
+		{"load", []interface{}{uint32(0xfffffff0)}, ErrTrapOutOfBoundsMemoryAccess},
+		{"recurse", nil, ErrTrapStackOverflow},
+	}
+	for _, c := range cases {
+		fn, err := runtime.FindFunction(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fn.Call(c.args...)
+		if !errors.Is(err, c.sentinel) {
+			t.Fatalf("%s%v: expected %v, got %v", c.name, c.args, c.sentinel, err)
+		}
+		var trapErr *TrapError
+		if !errors.As(err, &trapErr) {
+			t.Fatalf("%s: expected a *TrapError, got %T", c.name, err)
+		}
+	}
+
+	// FindFunction compiles the function, so missing imports are reported there
+	_, err := runtime.FindFunction("call_missing")
+	var linkErr *LinkError
+	if !errors.Is(err, ErrFunctionImportMissing) || !errors.As(err, &linkErr) {
+		t.Fatalf("Expected a missing import error, got %v", err)
+	}
+	_, err = runtime.FindFunction("undefined")
+	if !errors.Is(err, ErrFunctionLookupFailed) || !errors.As(err, &linkErr) {
+		t.Fatalf("Expected a function lookup error, got %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	env := NewEnvironment()
+	defer env.Destroy()
+	inputs := map[string][]byte{
+		"garbage":   []byte("garbage!"),
+		"version":   {0x00, 0x61, 0x73, 0x6d, 0x02, 0x00, 0x00, 0x00},
+		"truncated": {0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x05, 0x01},
+	}
+	for name, input := range inputs {
+		_, err := env.ParseModule(input)
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) {
+			t.Fatalf("%s: expected a *CompileError, got %v", name, err)
+		}
+		if errors.Unwrap(err) == nil {
+			t.Fatalf("%s: expected a sentinel error", name)
+		}
+	}
+}
+
+func TestConcurrentErrors(t *testing.T) {
+	const workers = 8
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			errs <- concurrentErrorsWorker(i)
+		}(i)
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// concurrentErrorsWorker runs its own runtime and checks that it gets its own errors back
+func concurrentErrorsWorker(i int) error {
+	runtime := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	defer runtime.Destroy()
+	hostErr := fmt.Errorf("worker %d", i)
+	if err := runtime.RegisterHostFunc("env", "fail", func() (int32, error) {
+		return 0, hostErr
+	}); err != nil {
+		return err
+	}
+	m := trapTestModule()
+	m.imports[0] = testImport{module: "env", field: "fail", results: []byte{i32}}
+	if _, err := runtime.Load(m.bytes()); err != nil {
+		return err
+	}
+	name, args, expected := "div", []interface{}{1, 0}, ErrTrapDivisionByZero
+	switch i % 3 {
+	case 1:
+		name, args, expected = "unreachable", nil, ErrTrapUnreachable
+	case 2:
+		name, args, expected = "call_missing", nil, hostErr
+	}
+	fn, err := runtime.FindFunction(name)
+	if err != nil {
+		return err
+	}
+	for n := 0; n < 100; n++ {
+		if _, err := fn.Call(args...); !errors.Is(err, expected) {
+			return fmt.Errorf("worker %d: expected %v, got %v", i, expected, err)
+		}
+	}
+	return nil
+}
+
+func TestErrorDetails(t *testing.T) {
+	runtime := loadTestModule(t, trapTestModule())
+	defer runtime.Destroy()
+
+	_, err := runtime.FindFunction("call_missing")
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected an *Error, got %T", err)
+	}
+	if e.Function != "call_missing" || e.Module == "" || e.File == "" || e.Line == 0 {
+		t.Fatalf("Missing error details: %#v", e)
+	}
+	if !strings.Contains(e.Message, "env.missing") || !strings.Contains(e.Error(), "env.missing") {
+		t.Fatalf("Expected the missing import in the message, got %q", e.Message)
+	}
+
+	// The info of the previous error must not leak into the next one
+	fn, err := runtime.FindFunction("unreachable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fn.Call()
+	e, ok = err.(*Error)
+	if !ok {
+		t.Fatalf("Expected an *Error, got %T", err)
+	}
+	if e.Function != "unreachable" || e.Message != "" || e.File != "" {
+		t.Fatalf("Unexpected error details: %#v", e)
+	}
+	if e.Error() != "[trap] unreachable executed in unreachable" {
+		t.Fatalf("Unexpected message: %q", e)
+	}
+}
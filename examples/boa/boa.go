@@ -1,25 +1,30 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"io/ioutil"
 	golog "log"
+	"time"
 
 	wasm3 "github.com/matiasinsaurralde/go-wasm3"
 )
 
 var (
-	allocateFn wasm3.FunctionWrapper
-	execFn     wasm3.FunctionWrapper
-	runtime    *wasm3.Runtime
-	print      = golog.Print
-	printf     = golog.Printf
+	execFn  *wasm3.Function
+	runtime *wasm3.Runtime
+	print   = golog.Print
+	printf  = golog.Printf
 )
 
 const (
 	wasmFilename = "boa.wasm"
 )
 
+var (
+	// execTimeout stops scripts that don't finish, e.g. infinite loops
+	execTimeout = 5 * time.Second
+)
+
 func initRuntimeAndModule() error {
 	runtime = wasm3.NewRuntime(&wasm3.Config{
 		Environment: wasm3.NewEnvironment(),
@@ -43,8 +48,7 @@ func initRuntimeAndModule() error {
 }
 
 func mapCalls() error {
-	var err error
-	allocateFn, err = runtime.FindFunction("boa_alloc")
+	err := runtime.UseAllocator("boa_alloc", "boa_dealloc")
 	if err != nil {
 		return err
 	}
@@ -56,36 +60,34 @@ func mapCalls() error {
 	return nil
 }
 
-func allocate(input string) (int, error) {
-	ptr, err := allocateFn(len(input))
+// run copies the script into the WASM memory and executes it
+func run(input string) (string, error) {
+	ptr, err := runtime.AllocBytes([]byte(input))
 	if err != nil {
-		return 0, nil
-	}
-	pos := ptr
-	for _, ch := range input {
-		runtime.Memory()[pos] = byte(ch)
-		pos++
+		return "", err
 	}
-	return ptr, nil
+	// boa_exec3 takes ownership of the input and frees it
+	runtime.Forget(ptr)
+	printf("Allocated %d bytes in WASM memory (\"boa_alloc\"), pointer is %d\n", len(input), ptr)
+	printf("Calling \"boa_exec3\" with arguments: (ptr=%d, length=%d)\n", ptr, len(input))
+	return exec(ptr, uint32(len(input)))
 }
 
-func exec(ptr, length int) (string, error) {
-	outPtr, err := execFn(ptr, length)
+func exec(ptr wasm3.GuestPtr, length uint32) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+	result, err := execFn.CallContext(ctx, ptr, length)
 	if err != nil {
 		return "", err
 	}
+	outPtr := int(result.(int32))
 	printf("\"boa_exec3\" returned, output pointer is %d\n", outPtr)
-	buf := new(bytes.Buffer)
-	for {
-		ch := runtime.Memory()[outPtr]
-		if ch == 0 {
-			break
-		}
-		buf.WriteByte(ch)
-		outPtr++
+	out, err := runtime.Memory().ReadCString(uint32(outPtr))
+	if err != nil {
+		return "", err
 	}
-	printf("Read %d bytes from WASM memory, starting in %d\n", buf.Len(), outPtr)
-	return buf.String(), nil
+	printf("Read %d bytes from WASM memory, starting in %d\n", len(out), outPtr)
+	return out, nil
 }
 
 func main() {
@@ -99,14 +101,9 @@ func main() {
 		panic(err)
 	}
 	jsInput := "var s=\"test\"; typeof(s)"
-	inputLength := len(jsInput)
 	printf(("JS Input is: %s\n"), jsInput)
 
-	ptr, err := allocate(jsInput)
-	printf("Allocated %d bytes in WASM memory (\"boa_alloc\"), pointer is %d\n", inputLength, ptr)
-
-	printf("Calling \"boa_exec3\" with arguments: (ptr=%d, length=%d)\n", ptr, inputLength)
-	out, err := exec(ptr, inputLength)
+	out, err := run(jsInput)
 	if err != nil {
 		panic(err)
 	}
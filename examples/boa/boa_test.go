@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
 	"testing"
+	"time"
+
+	wasm3 "github.com/matiasinsaurralde/go-wasm3"
 )
 
 func init() {
@@ -19,10 +23,7 @@ func init() {
 
 func boaCall(t testing.TB) {
 	jsInput := "var s=\"test\"; typeof(s)"
-	inputLength := len(jsInput)
-
-	ptr, err := allocate(jsInput)
-	out, err := exec(ptr, inputLength)
+	out, err := run(jsInput)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -40,3 +41,15 @@ func BenchmarkBoaCall(b *testing.B) {
 		boaCall(b)
 	}
 }
+
+func TestBoaInfiniteLoop(t *testing.T) {
+	defer func(timeout time.Duration) {
+		execTimeout = timeout
+	}(execTimeout)
+	execTimeout = 100 * time.Millisecond
+
+	jsInput := "while (true) {}"
+	if _, err := run(jsInput); !errors.Is(err, wasm3.ErrInterrupted) {
+		t.Fatalf("Expected the script to be interrupted, got %v", err)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"io/ioutil"
 	"log"
 
@@ -44,23 +43,15 @@ func main() {
 	log.Printf("Found '%s' function (using runtime.FindFunction)", fnName)
 	memoryLength := runtime.GetAllocatedMemoryLength()
 	log.Printf("Allocated memory (before function call) is: %d\n", memoryLength)
-	result, _ := fn()
+	result, _ := fn.Call()
 	memoryLength = runtime.GetAllocatedMemoryLength()
 	log.Printf("Allocated memory (after function call) is: %d\n", memoryLength)
 
-	// Reconstruct the string from memory:
-	mem := runtime.Memory()
-	buf := new(bytes.Buffer)
-	for n := 0; n < memoryLength; n++ {
-		if n < result {
-			continue
-		}
-		value := mem[n]
-		if value == 0 {
-			break
-		}
-		buf.WriteByte(value)
+	// Read the string from memory:
+	str, err := runtime.Memory().ReadCString(uint32(result.(int32)))
+	if err != nil {
+		panic(err)
 	}
-	log.Printf("Buffer length is: %d\n", buf.Len())
-	log.Printf("Buffer contains: %s\n", buf.String())
+	log.Printf("String length is: %d\n", len(str))
+	log.Printf("String contains: %s\n", str)
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"io/ioutil"
 	"testing"
 
@@ -34,23 +33,12 @@ func TestCString(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	result, _ := fn()
-	memoryLength := runtime.GetAllocatedMemoryLength()
-
-	// Reconstruct the string from memory:
-	mem := runtime.Memory()
-	buf := new(bytes.Buffer)
-	for n := 0; n < memoryLength; n++ {
-		if n < result {
-			continue
-		}
-		value := mem[n]
-		if value == 0 {
-			break
-		}
-		buf.WriteByte(value)
+	result, _ := fn.Call()
+	str, err := runtime.Memory().ReadCString(uint32(result.(int32)))
+	if err != nil {
+		t.Fatal(err)
 	}
-	if buf.String() != "testingonly" {
+	if str != "testingonly" {
 		t.Fatal("Reconstructed string doesn't match")
 	}
 }
@@ -70,23 +58,12 @@ func BenchmarkCString(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		result, _ := fn()
-		memoryLength := runtime.GetAllocatedMemoryLength()
-
-		// Reconstruct the string from memory:
-		mem := runtime.Memory()
-		buf := new(bytes.Buffer)
-		for n := 0; n < memoryLength; n++ {
-			if n < result {
-				continue
-			}
-			value := mem[n]
-			if value == 0 {
-				break
-			}
-			buf.WriteByte(value)
+		result, _ := fn.Call()
+		str, err := runtime.Memory().ReadCString(uint32(result.(int32)))
+		if err != nil {
+			b.Fatal(err)
 		}
-		if buf.String() != "testingonly" {
+		if str != "testingonly" {
 			b.Fatal("Reconstructed string doesn't match")
 		}
 	}
@@ -107,24 +84,43 @@ func BenchmarkCStringReentrant(b *testing.B) {
 		b.Fatal(err)
 	}
 	for n := 0; n < b.N; n++ {
-		result, _ := fn()
-		memoryLength := runtime.GetAllocatedMemoryLength()
+		result, _ := fn.Call()
+		str, err := runtime.Memory().ReadCString(uint32(result.(int32)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if str != "testingonly" {
+			b.Fatal("Reconstructed string doesn't match")
+		}
+	}
+}
 
-		// Reconstruct the string from memory:
-		mem := runtime.Memory()
-		buf := new(bytes.Buffer)
-		for n := 0; n < memoryLength; n++ {
-			if n < result {
-				continue
-			}
-			value := mem[n]
-			if value == 0 {
-				break
-			}
-			buf.WriteByte(value)
+func BenchmarkCStringRestore(b *testing.B) {
+	runtime := wasm3.NewRuntime(&wasm3.Config{
+		Environment: wasm3.NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	defer runtime.Destroy()
+	_, err := runtime.Load(wasmBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fn, err := runtime.FindFunction(fnName)
+	if err != nil {
+		b.Fatal(err)
+	}
+	snapshot := runtime.Snapshot()
+	for n := 0; n < b.N; n++ {
+		result, _ := fn.Call()
+		str, err := runtime.Memory().ReadCString(uint32(result.(int32)))
+		if err != nil {
+			b.Fatal(err)
 		}
-		if buf.String() != "testingonly" {
+		if str != "testingonly" {
 			b.Fatal("Reconstructed string doesn't match")
 		}
+		if err := runtime.Restore(snapshot); err != nil {
+			b.Fatal(err)
+		}
 	}
 }
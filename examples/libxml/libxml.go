@@ -1,16 +1,17 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	golog "log"
+	"time"
 
 	wasm3 "github.com/matiasinsaurralde/go-wasm3"
 )
 
 var (
-	allocateFn        wasm3.FunctionWrapper
-	newSchemaParserFn wasm3.FunctionWrapper
-	validateFn        wasm3.FunctionWrapper
+	newSchemaParserFn *wasm3.Function
+	validateFn        *wasm3.Function
 	runtime           *wasm3.Runtime
 	print             = golog.Print
 	printf            = golog.Printf
@@ -18,6 +19,8 @@ var (
 
 const (
 	wasmFilename = "libxml2.wasm"
+	// validateTimeout stops validations that don't finish on hostile input
+	validateTimeout = 5 * time.Second
 )
 
 func initRuntimeAndModule() error {
@@ -44,8 +47,8 @@ func initRuntimeAndModule() error {
 }
 
 func mapCalls() error {
-	var err error
-	allocateFn, err = runtime.FindFunction("wasm_allocate")
+	// The module doesn't export a free function, the buffers live as long as the runtime
+	err := runtime.UseAllocator("wasm_allocate", "")
 	if err != nil {
 		return err
 	}
@@ -62,30 +65,22 @@ func mapCalls() error {
 	return nil
 }
 
-func allocate(input []byte) (int, error) {
-	ptr, err := allocateFn(len(input))
+func newSchemaParser(ptr wasm3.GuestPtr, length int) (int, error) {
+	outPtr, err := newSchemaParserFn.Call(ptr, length)
 	if err != nil {
-		return 0, nil
-	}
-	pos := ptr
-	for _, ch := range input {
-		runtime.Memory()[pos] = byte(ch)
-		pos++
+		return 0, err
 	}
-	return ptr, nil
+	return int(outPtr.(int32)), nil
 }
 
-func newSchemaParser(ptr, length int) (int, error) {
-	outPtr, err := newSchemaParserFn(ptr, length)
+func validate(xmlPtr wasm3.GuestPtr, xmlLength, schemaParserPtr int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+	out, err := validateFn.CallContext(ctx, xmlPtr, xmlLength, schemaParserPtr)
 	if err != nil {
 		return 0, err
 	}
-	return outPtr, nil
-}
-
-func validate(xmlPtr, xmlLength, schemaParserPtr int) (int, error) {
-	out, err := validateFn(xmlPtr, xmlLength, schemaParserPtr)
-	return out, err
+	return int(out.(int32)), nil
 }
 
 func main() {
@@ -102,7 +97,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	xsdPtr, err := allocate(xsdFile)
+	xsdPtr, err := runtime.AllocBytes(xsdFile)
 	if err != nil {
 		panic(err)
 	}
@@ -111,7 +106,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	xmlPtr, err := allocate(xmlFile)
+	xmlPtr, err := runtime.AllocBytes(xmlFile)
 	if err != nil {
 		panic(err)
 	}
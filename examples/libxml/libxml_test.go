@@ -3,6 +3,8 @@ package main
 import (
 	"io/ioutil"
 	"testing"
+
+	wasm3 "github.com/matiasinsaurralde/go-wasm3"
 )
 
 var (
@@ -10,9 +12,9 @@ var (
 	goodXMLData, _ = ioutil.ReadFile("input.xml")
 	xsdData, _     = ioutil.ReadFile("input.xsd")
 
-	badXMLPtr       int
-	goodXMLPtr      int
-	xsdPtr          int
+	badXMLPtr       wasm3.GuestPtr
+	goodXMLPtr      wasm3.GuestPtr
+	xsdPtr          wasm3.GuestPtr
 	schemaParserPtr int
 )
 
@@ -28,15 +30,15 @@ func init() {
 		panic(err)
 	}
 
-	badXMLPtr, err = allocate(badXMLData)
+	badXMLPtr, err = runtime.AllocBytes(badXMLData)
 	if err != nil {
 		panic(err)
 	}
-	goodXMLPtr, err = allocate(goodXMLData)
+	goodXMLPtr, err = runtime.AllocBytes(goodXMLData)
 	if err != nil {
 		panic(err)
 	}
-	xsdPtr, err = allocate(xsdData)
+	xsdPtr, err = runtime.AllocBytes(xsdData)
 	if err != nil {
 		panic(err)
 	}
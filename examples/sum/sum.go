@@ -42,7 +42,7 @@ func main() {
 		panic(err)
 	}
 	log.Printf("Found '%s' function (using runtime.FindFunction)", fnName)
-	result, _ := fn(1, 1)
+	result, _ := fn.Call(1, 1)
 	log.Print("Result is: ", result)
 
 	// Different call approach, retrieving functions from the module object:
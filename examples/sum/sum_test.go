@@ -33,8 +33,8 @@ func TestSum(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	result, _ := fn(1, 1)
-	if result != 2 {
+	result, _ := fn.Call(1, 1)
+	if result != int32(2) {
 		t.Fatal("Result doesn't match")
 	}
 }
@@ -54,7 +54,7 @@ func BenchmarkSum(b *testing.B) {
 		if err != nil {
 			b.Fatal(err)
 		}
-		fn(1, 2)
+		fn.Call(1, 2)
 	}
 }
 
@@ -73,6 +73,6 @@ func BenchmarkSumReentrant(b *testing.B) {
 		b.Fatal(err)
 	}
 	for n := 0; n < b.N; n++ {
-		fn(1, 2)
+		fn.Call(1, 2)
 	}
 }
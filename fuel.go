@@ -0,0 +1,39 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+var(
+	// ErrOutOfFuel is returned when a metered call runs out of fuel
+	ErrOutOfFuel = m3Error(C.goErr_outOfFuel)
+)
+
+// CallWithFuel works like Call but traps with ErrOutOfFuel once the budget is exhausted.
+// Fuel is charged by block weight, the number of compiled ops: a function entry costs one
+// plus the ops of the function outside of loops, and every loop iteration one plus the ops of
+// the loop body outside of nested loops, charged when the block starts. The same call with
+// the same input always consumes the same amount of fuel. It returns the consumed fuel, which
+// is the whole budget when the call runs out of fuel.
+func(f *Function) CallWithFuel(budget uint64, args... interface{}) (interface{}, uint64, error) {
+	if !execHooksSupported {
+		return nil, 0, errExecHooksNotSupported
+	}
+	if f.runtime == nil {
+		return nil, 0, errModuleNotLoaded
+	}
+	ctrl := f.runtime.ctrl
+	ctrl.metered = 1
+	ctrl.fuel = C.uint64_t(budget)
+	result, err := f.call(args...)
+	consumed := budget - uint64(ctrl.fuel)
+	ctrl.metered = 0
+	f.runtime.fuelConsumed = consumed
+	return result, consumed, err
+}
+
+// FuelConsumed returns the fuel consumed by the last metered call
+func(r *Runtime) FuelConsumed() uint64 {
+	return r.fuelConsumed
+}
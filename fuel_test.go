@@ -0,0 +1,134 @@
+package wasm3
+
+import (
+	"errors"
+	"testing"
+)
+
+func fuelTestModule() *testModule {
+	m := interruptTestModule()
+	// count loops n times
+	m.addFunc(testFunc{export: "count", params: []byte{i32},
+		code: []byte{0x03, 0x40, 0x20, 0x00, 0x41, 0x01, 0x6b, 0x22, 0x00, 0x0d, 0x00, 0x0b}})
+	// countSlow loops n times too, multiplying and adding in the loop body
+	m.addFunc(testFunc{export: "countSlow", params: []byte{i32},
+		code: []byte{0x03, 0x40, 0x20, 0x00, 0x41, 0x01, 0x6b, 0x41, 0x03, 0x6c, 0x41, 0x03, 0x6d,
+			0x41, 0x00, 0x6a, 0x22, 0x00, 0x0d, 0x00, 0x0b}})
+	return m
+}
+
+// fuelOf returns the fuel consumed by a call of fn with n
+func fuelOf(t *testing.T, fn *Function, n int) uint64 {
+	t.Helper()
+	_, consumed, err := fn.CallWithFuel(100000, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn.runtime.FuelConsumed() != consumed {
+		t.Fatalf("FuelConsumed returned %d, expected %d", fn.runtime.FuelConsumed(), consumed)
+	}
+	return consumed
+}
+
+func TestCallWithFuel(t *testing.T) {
+	if !execHooksSupported {
+		t.Skip("Fuel metering isn't supported on this platform")
+	}
+	runtime := loadTestModule(t, fuelTestModule())
+	defer runtime.Destroy()
+
+	count, err := runtime.FindFunction("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The function entry is charged once and the loop body on every iteration
+	entry := fuelOf(t, count, 1)
+	iteration := fuelOf(t, count, 2) - entry
+	if iteration <= 1 {
+		t.Fatalf("Expected the loop body to weigh more than one op, got %d", iteration)
+	}
+	for _, n := range []int{1, 10, 1000} {
+		for i := 0; i < 2; i++ {
+			expected := entry + uint64(n-1)*iteration
+			if consumed := fuelOf(t, count, n); consumed != expected {
+				t.Fatalf("count(%d): expected %d units of fuel, got %d", n, expected, consumed)
+			}
+		}
+	}
+	_, consumed, err := count.CallWithFuel(100, 1000)
+	if !errors.Is(err, ErrOutOfFuel) || consumed != 100 {
+		t.Fatalf("Expected to run out of fuel, got %v (%d)", err, consumed)
+	}
+	var trapErr *TrapError
+	if !errors.As(err, &trapErr) {
+		t.Fatalf("Expected a *TrapError, got %T", err)
+	}
+
+	// Calls between guest functions are charged too
+	ping, err := runtime.FindFunction("ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one, ten := fuelOf(t, ping, 1), fuelOf(t, ping, 10); ten <= one {
+		t.Fatalf("Expected ping(10) to consume more than ping(1), got %d and %d", ten, one)
+	}
+
+	// Calls aren't metered without a budget
+	if _, err := count.Call(100000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFuelBlockWeight(t *testing.T) {
+	if !execHooksSupported {
+		t.Skip("Fuel metering isn't supported on this platform")
+	}
+	runtime := loadTestModule(t, fuelTestModule())
+	defer runtime.Destroy()
+
+	count, err := runtime.FindFunction("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	countSlow, err := runtime.FindFunction("countSlow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iteration := fuelOf(t, count, 11) - fuelOf(t, count, 10)
+	slowIteration := fuelOf(t, countSlow, 11) - fuelOf(t, countSlow, 10)
+	if slowIteration <= iteration {
+		t.Fatalf("Expected the longer loop body to consume more fuel per iteration, got %d and %d", slowIteration, iteration)
+	}
+}
+
+func TestConfigFuel(t *testing.T) {
+	if !execHooksSupported {
+		t.Skip("Fuel metering isn't supported on this platform")
+	}
+	runtime := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+		Fuel:        1000,
+	})
+	defer runtime.Destroy()
+	if _, err := runtime.Load(fuelTestModule().bytes()); err != nil {
+		t.Fatal(err)
+	}
+	spin, err := runtime.FindFunction("spin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := spin.Call(); !errors.Is(err, ErrOutOfFuel) {
+		t.Fatalf("Expected to run out of fuel, got %v", err)
+	}
+	count, err := runtime.FindFunction("count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := count.Call(10); err != nil {
+		t.Fatal(err)
+	}
+	if consumed := runtime.FuelConsumed(); consumed != fuelOf(t, count, 10) {
+		t.Fatalf("Expected Call to consume as much fuel as CallWithFuel, got %d", consumed)
+	}
+}
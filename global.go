@@ -0,0 +1,149 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"errors"
+	"fmt"
+)
+
+var(
+	// ErrGlobalNotFound is returned when a module doesn't export the requested global
+	ErrGlobalNotFound = errors.New("Global not found")
+	// ErrGlobalImmutable is returned when setting a global that isn't mutable
+	ErrGlobalImmutable = errors.New("Global is immutable")
+	// ErrGlobalImportMissing is returned by LoadModule when Config.StrictImports is set
+	// and a global import isn't defined with DefineGlobal
+	ErrGlobalImportMissing = errors.New("missing imported global")
+	// ErrGlobalImportMismatch is returned by LoadModule when a global defined with DefineGlobal
+	// doesn't have the type or mutability of the import
+	ErrGlobalImportMismatch = errors.New("imported global type mismatch")
+)
+
+// Global is a handle to a module global, values are int32, int64, float32 or float64 like function results.
+type Global struct {
+	Name string
+	module *Module
+	ptr C.IM3Global
+}
+
+// Global returns the exported global with the given name, the module must be loaded into a runtime.
+// Global exports are only known for modules parsed from a WASM binary.
+func(m *Module) Global(name string) (*Global, error) {
+	if m.runtime == nil {
+		return nil, errModuleNotLoaded
+	}
+	if m.sections == nil {
+		return nil, fmt.Errorf("%w: %s", ErrGlobalNotFound, name)
+	}
+	for _, e := range m.sections.exports {
+		if e.kind != ExternGlobal || e.name != name {
+			continue
+		}
+		if e.index >= uint32(m.Ptr().numGlobals) {
+			break
+		}
+		return &Global{
+			Name: name,
+			module: m,
+			ptr: moduleGlobal(m.Ptr(), e.index),
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrGlobalNotFound, name)
+}
+
+// Type returns the global type and mutability
+func(g *Global) Type() *GlobalType {
+	return newGlobalType(g.ptr)
+}
+
+// Get returns the global value
+func(g *Global) Get() interface{} {
+	return fromSlot(uint64(C.global_get_bits(g.ptr)), ValueType(g.ptr._type))
+}
+
+// Set changes the global value, it fails with ErrGlobalImmutable if the global isn't mutable.
+// The value is converted like function arguments, e.g. any integer that fits is accepted for i32.
+func(g *Global) Set(v interface{}) error {
+	if !bool(g.ptr.isMutable) {
+		return fmt.Errorf("%w: %s", ErrGlobalImmutable, g.Name)
+	}
+	bits, err := toSlot(v, ValueType(g.ptr._type))
+	if err != nil {
+		return fmt.Errorf("global %s: %s", g.Name, err)
+	}
+	C.global_set_bits(g.ptr, C.uint64_t(bits))
+	return nil
+}
+
+// definedGlobal is a global import defined with DefineGlobal
+type definedGlobal struct {
+	moduleName string
+	fieldName string
+	value Value
+	mutable bool
+}
+
+// DefineGlobal provides the value of the global imports matching moduleName and fieldName,
+// e.g. DefineGlobal("env", "__memory_base", wasm3.I32(1024), false).
+// The value type and mutability must match the import. It applies to the modules loaded afterwards,
+// each module gets its own copy of the value.
+func(r *Runtime) DefineGlobal(moduleName, fieldName string, value Value, mutable bool) error {
+	if value == nil {
+		return fmt.Errorf("Global %s.%s has no value", moduleName, fieldName)
+	}
+	g := &definedGlobal{
+		moduleName: moduleName,
+		fieldName: fieldName,
+		value: value,
+		mutable: mutable,
+	}
+	for i, defined := range r.globals {
+		if defined.moduleName == moduleName && defined.fieldName == fieldName {
+			r.globals[i] = g
+			return nil
+		}
+	}
+	r.globals = append(r.globals, g)
+	return nil
+}
+
+// linkGlobals sets the global imports defined with DefineGlobal, it returns the imports left undefined
+func(r *Runtime) linkGlobals(m *Module) ([]Import, error) {
+	var missing []Import
+	module := m.Ptr()
+	for i := uint32(0); i < uint32(module.numGlobals); i++ {
+		g := moduleGlobal(module, i)
+		if !bool(g.imported) || g._import.moduleUtf8 == nil || g._import.fieldUtf8 == nil {
+			continue
+		}
+		imp := Import{
+			Module: C.GoString(g._import.moduleUtf8),
+			Field: C.GoString(g._import.fieldUtf8),
+			ExternType: m.externType(ExternGlobal, i),
+		}
+		defined := r.findGlobal(imp.Module, imp.Field)
+		if defined == nil {
+			missing = append(missing, imp)
+			continue
+		}
+		if defined.value.Type() != imp.Global.Type || defined.mutable != imp.Global.Mutable {
+			return nil, &LinkError{Err: fmt.Errorf("%w: %s.%s is %s (mutable: %t), got %s (mutable: %t)", ErrGlobalImportMismatch,
+				imp.Module, imp.Field, imp.Global.Type, imp.Global.Mutable, defined.value.Type(), defined.mutable)}
+		}
+		C.global_set_bits(g, C.uint64_t(defined.value.bits()))
+	}
+	return missing, nil
+}
+
+func(r *Runtime) findGlobal(moduleName, fieldName string) *definedGlobal {
+	for _, g := range r.globals {
+		if g.moduleName == moduleName && g.fieldName == fieldName {
+			return g
+		}
+	}
+	return nil
+}
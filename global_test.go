@@ -0,0 +1,180 @@
+package wasm3
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func globalTestModule() *testModule {
+	m := &testModule{}
+	f32Init := make([]byte, 5)
+	f32Init[0] = 0x43
+	binary.LittleEndian.PutUint32(f32Init[1:], math.Float32bits(0.5))
+	f64Init := make([]byte, 9)
+	f64Init[0] = 0x44
+	binary.LittleEndian.PutUint64(f64Init[1:], math.Float64bits(-2.5))
+	counter := byte(m.addGlobal(testGlobal{typ: i32, mutable: true, init: []byte{0x41, 0}, export: "counter"}))
+	m.addGlobal(testGlobal{typ: i64, init: append([]byte{0x42}, sleb(1<<40)...), export: "limit"})
+	ratio := byte(m.addGlobal(testGlobal{typ: f32, mutable: true, init: f32Init, export: "ratio"}))
+	m.addGlobal(testGlobal{typ: f64, mutable: true, init: f64Init, export: "scale"})
+	m.addFunc(testFunc{export: "incr", results: []byte{i32},
+		code: []byte{0x23, counter, 0x41, 1, 0x6a, 0x24, counter, 0x23, counter}})
+	m.addFunc(testFunc{export: "get_ratio", results: []byte{f32}, code: []byte{0x23, ratio}})
+	return m
+}
+
+func TestGlobals(t *testing.T) {
+	runtime := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	defer runtime.Destroy()
+	module, err := runtime.ParseModule(globalTestModule().bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := module.Global("counter"); err != errModuleNotLoaded {
+		t.Fatalf("Expected errModuleNotLoaded, got %v", err)
+	}
+	if _, err := runtime.LoadModule(module); err != nil {
+		t.Fatal(err)
+	}
+
+	counter, err := module.Global("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := counter.Type(); typ.Type != TypeI32 || !typ.Mutable {
+		t.Fatalf("Unexpected type: %+v", typ)
+	}
+	callFunction(t, runtime, "incr")
+	if v := counter.Get(); v != int32(1) {
+		t.Fatalf("Unexpected value: %v", v)
+	}
+	if err := counter.Set(41); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "incr"); v != int32(42) {
+		t.Fatalf("The guest read %v", v)
+	}
+	if err := counter.Set(1.5); err == nil {
+		t.Fatal("Setting a float to an i32 global should error")
+	}
+
+	limit, err := module.Global("limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := limit.Get(); v != int64(1<<40) {
+		t.Fatalf("Unexpected value: %v", v)
+	}
+	if err := limit.Set(int64(1)); !errors.Is(err, ErrGlobalImmutable) {
+		t.Fatalf("Expected ErrGlobalImmutable, got %v", err)
+	}
+
+	ratio, err := module.Global("ratio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := ratio.Get(); v != float32(0.5) {
+		t.Fatalf("Unexpected value: %v", v)
+	}
+	if err := ratio.Set(float32(0.25)); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "get_ratio"); v != float32(0.25) {
+		t.Fatalf("The guest read %v", v)
+	}
+	scale, err := module.Global("scale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := scale.Get(); v != -2.5 {
+		t.Fatalf("Unexpected value: %v", v)
+	}
+
+	if _, err := module.Global("incr"); !errors.Is(err, ErrGlobalNotFound) {
+		t.Fatalf("Expected ErrGlobalNotFound, got %v", err)
+	}
+}
+
+func importedGlobalsTestModule() *testModule {
+	m := &testModule{}
+	base := byte(m.addImport(testImport{module: "env", field: "__memory_base", global: &testGlobal{typ: i32}}))
+	sp := byte(m.addImport(testImport{module: "env", field: "__stack_pointer", global: &testGlobal{typ: i32, mutable: true}}))
+	m.addFunc(testFunc{export: "get_base", results: []byte{i32}, code: []byte{0x23, base}})
+	// push moves the stack pointer down and returns it
+	m.addFunc(testFunc{export: "push", params: []byte{i32}, results: []byte{i32},
+		code: []byte{0x23, sp, 0x20, 0, 0x6b, 0x24, sp, 0x23, sp}})
+	return m
+}
+
+func TestDefineGlobal(t *testing.T) {
+	newRuntime := func(strict bool) *Runtime {
+		return NewRuntime(&Config{
+			Environment:   NewEnvironment(),
+			StackSize:     64 * 1024,
+			StrictImports: strict,
+		})
+	}
+	runtime := newRuntime(true)
+	defer runtime.Destroy()
+	if err := runtime.DefineGlobal("env", "__memory_base", I32(1024), false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := runtime.Load(importedGlobalsTestModule().bytes())
+	var linkErr *LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(err, ErrGlobalImportMissing) || len(linkErr.Missing) != 1 {
+		t.Fatalf("Expected the missing __stack_pointer, got %v", err)
+	}
+	if linkErr.Missing[0].Field != "__stack_pointer" {
+		t.Fatalf("Unexpected missing import: %s", linkErr.Missing[0])
+	}
+
+	if err := runtime.DefineGlobal("env", "__stack_pointer", I64(4096), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.Load(importedGlobalsTestModule().bytes()); !errors.Is(err, ErrGlobalImportMismatch) {
+		t.Fatalf("Expected ErrGlobalImportMismatch, got %v", err)
+	}
+	if err := runtime.DefineGlobal("env", "__stack_pointer", I32(4096), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.Load(importedGlobalsTestModule().bytes()); !errors.Is(err, ErrGlobalImportMismatch) {
+		t.Fatalf("Expected ErrGlobalImportMismatch, got %v", err)
+	}
+	if err := runtime.DefineGlobal("env", "__stack_pointer", I32(4096), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.Load(importedGlobalsTestModule().bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "get_base"); v != int32(1024) {
+		t.Fatalf("Unexpected __memory_base: %v", v)
+	}
+	if v := callFunction(t, runtime, "push", I32(16)); v != int32(4080) {
+		t.Fatalf("Unexpected __stack_pointer: %v", v)
+	}
+	if v := callFunction(t, runtime, "push", 16); v != int32(4064) {
+		t.Fatalf("Unexpected __stack_pointer: %v", v)
+	}
+	fn, err := runtime.FindFunction("push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn.Call(I64(16)); err == nil {
+		t.Fatal("Passing an I64 to an i32 parameter should error")
+	}
+
+	// Without StrictImports undefined globals are zero
+	lenient := newRuntime(false)
+	defer lenient.Destroy()
+	if _, err := lenient.Load(importedGlobalsTestModule().bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, lenient, "get_base"); v != int32(0) {
+		t.Fatalf("Unexpected __memory_base: %v", v)
+	}
+}
@@ -1,2 +1,34 @@
 #include "m3_env.h"
-void set_error(M3Result);
\ No newline at end of file
+
+// go_call_ctrl is shared between Go and the running guest, it's checked
+// on function entries and loop iterations (see interrupt_linux.go)
+typedef struct go_call_ctrl {
+	int32_t interrupted;
+	int32_t metered;
+	uint64_t fuel;
+	// maxPages is Config.MaxMemoryPages, deniedPages is set when a grow
+	// above it fails (see memory_linux.go)
+	uint32_t maxPages;
+	uint32_t deniedPages;
+} go_call_ctrl;
+
+extern __thread go_call_ctrl* go_current_ctrl;
+
+IM3Function module_get_function(IM3Module, int);
+M3Result link_go_function(IM3Module, IM3Function, uint64_t);
+uint64_t get_go_function_id(IM3Function);
+M3Result go_host_call(uint64_t, uint64_t*);
+
+// global_get_bits and global_set_bits access the global value union,
+// i32 and f32 values use the low 32 bits
+static inline uint64_t global_get_bits(IM3Global g) {
+	return (uint64_t) g->intValue;
+}
+
+static inline void global_set_bits(IM3Global g, uint64_t bits) {
+	g->intValue = (i64) bits;
+}
+
+extern M3Result goErr_hostFunction;
+extern M3Result goErr_interrupted;
+extern M3Result goErr_outOfFuel;
@@ -0,0 +1,303 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+var(
+	errModuleNotLoaded = errors.New("Module isn't loaded into a runtime")
+	errHostFunctionNotFound = errors.New("Host function not found")
+)
+
+// HostFunction is a Go function that can be imported by a module.
+// The arguments are decoded from the WASM stack according to the import signature
+// and the returned value is converted to the result type (it's ignored for void functions).
+// Returning an error traps the guest, the call returns it wrapped in a *TrapError.
+type HostFunction func(ctx *HostContext, args []interface{}) (interface{}, error)
+
+// HostContext is passed to host functions
+type HostContext struct {
+	Runtime *Runtime
+}
+
+// Memory returns the runtime memory
+func(ctx *HostContext) Memory() *Memory {
+	return ctx.Runtime.Memory()
+}
+
+// hostFunction holds a registered host function
+type hostFunction struct {
+	runtime *Runtime
+	sig *Signature
+	fn HostFunction
+	name string
+}
+
+// hostFunctions maps the IDs that are stored in the compiled code to host functions
+var hostFunctions = struct {
+	sync.RWMutex
+	m map[uint64]*hostFunction
+	nextID uint64
+}{
+	m: make(map[uint64]*hostFunction),
+}
+
+// replaceHostFunction swaps a registered host function, callers that are already compiled keep the ID.
+func replaceHostFunction(id uint64, hf *hostFunction) {
+	hostFunctions.Lock()
+	defer hostFunctions.Unlock()
+	hostFunctions.m[id] = hf
+}
+
+func registerHostFunction(hf *hostFunction) uint64 {
+	hostFunctions.Lock()
+	defer hostFunctions.Unlock()
+	hostFunctions.nextID++
+	hostFunctions.m[hostFunctions.nextID] = hf
+	return hostFunctions.nextID
+}
+
+func unregisterHostFunctions(ids []uint64) {
+	hostFunctions.Lock()
+	defer hostFunctions.Unlock()
+	for _, id := range ids {
+		delete(hostFunctions.m, id)
+	}
+}
+
+//export go_host_call
+func go_host_call(id C.uint64_t, sp *C.uint64_t) (result C.M3Result) {
+	hostFunctions.RLock()
+	hf := hostFunctions.m[uint64(id)]
+	hostFunctions.RUnlock()
+	if hf == nil {
+		return C.m3Err_functionLookupFailed
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			hf.runtime.hostErr = fmt.Errorf("host function %s panicked: %v", hf.name, p)
+			result = C.goErr_hostFunction
+		}
+	}()
+	numSlots := len(hf.sig.Params)
+	if numSlots == 0 {
+		numSlots = 1
+	}
+	slots := (*[1 << 20]uint64)(unsafe.Pointer(sp))[:numSlots:numSlots]
+	args := make([]interface{}, len(hf.sig.Params))
+	for i, t := range hf.sig.Params {
+		args[i] = fromSlot(slots[i], t)
+	}
+	ret, err := hf.fn(&HostContext{Runtime: hf.runtime}, args)
+	if err == nil && hf.sig.Result != TypeNone {
+		slots[0], err = toSlot(ret, hf.sig.Result)
+		if err != nil {
+			err = fmt.Errorf("host function %s result: %s", hf.name, err)
+		}
+	}
+	if err != nil {
+		hf.runtime.hostErr = err
+		return C.goErr_hostFunction
+	}
+	return nil
+}
+
+// LinkFunction links a Go function to the module imports matching moduleName and fieldName.
+// The signature uses the WASM3 notation (e.g. "i(iI)") and must match the import type,
+// "*" can be used as moduleName to match any module.
+// The module must be loaded into a runtime.
+func(m *Module) LinkFunction(moduleName, fieldName, signature string, fn HostFunction) error {
+	if m.runtime == nil {
+		return errModuleNotLoaded
+	}
+	sig, err := ParseSignature(signature)
+	if err != nil {
+		return err
+	}
+	linked := false
+	for i := 0; i < m.NumFunctions(); i++ {
+		f := C.module_get_function(m.Ptr(), C.int(i))
+		if f._import.moduleUtf8 == nil || f._import.fieldUtf8 == nil {
+			continue
+		}
+		importModule := C.GoString(f._import.moduleUtf8)
+		importField := C.GoString(f._import.fieldUtf8)
+		if importField != fieldName || (moduleName != "*" && importModule != moduleName) {
+			continue
+		}
+		importSig := newSignature(f.funcType)
+		if !sig.Equal(importSig) {
+			return fmt.Errorf("Signature mismatch for %s.%s: import is %s, got %s", importModule, importField, importSig, sig)
+		}
+		hf := &hostFunction{
+			runtime: m.runtime,
+			sig: sig,
+			fn: fn,
+			name: importModule + "." + importField,
+		}
+		if id := uint64(C.get_go_function_id(f)); id != 0 {
+			replaceHostFunction(id, hf)
+			linked = true
+			continue
+		}
+		id := registerHostFunction(hf)
+		m.runtime.hostFunctions = append(m.runtime.hostFunctions, id)
+		result := C.link_go_function(m.Ptr(), f, C.uint64_t(id))
+		if result != nil {
+			return newError(result, kindLink)
+		}
+		linked = true
+	}
+	if !linked {
+		return errHostFunctionNotFound
+	}
+	return nil
+}
+
+// registeredHostFunc is a function registered with RegisterHostFunc
+type registeredHostFunc struct {
+	moduleName string
+	fieldName string
+	signature string
+	fn HostFunction
+}
+
+// link links the function into the module, modules that don't import it are skipped
+func(hf *registeredHostFunc) link(m *Module) error {
+	err := m.LinkFunction(hf.moduleName, hf.fieldName, hf.signature, hf.fn)
+	if err == errHostFunctionNotFound {
+		return nil
+	}
+	return err
+}
+
+var(
+	hostContextType = reflect.TypeOf((*HostContext)(nil))
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// valueTypeOf maps Go types to WASM types
+func valueTypeOf(t reflect.Type) (ValueType, bool) {
+	switch t.Kind() {
+	case reflect.Int32, reflect.Uint32:
+		return TypeI32, true
+	case reflect.Int64, reflect.Uint64:
+		return TypeI64, true
+	case reflect.Float32:
+		return TypeF32, true
+	case reflect.Float64:
+		return TypeF64, true
+	}
+	return TypeNone, false
+}
+
+// wrapHostFunc derives the signature of a Go function and wraps it as a HostFunction.
+// The function may take a *HostContext as its first parameter, followed by int32, uint32,
+// int64, uint64, float32 or float64 parameters. It may return one value of those types,
+// an error, or both.
+func wrapHostFunc(fn interface{}) (*Signature, HostFunction, error) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, nil, fmt.Errorf("Host function must be a func, got %s", t)
+	}
+	if t.IsVariadic() {
+		return nil, nil, fmt.Errorf("Host function can't be variadic: %s", t)
+	}
+	sig := &Signature{}
+	withContext := t.NumIn() > 0 && t.In(0) == hostContextType
+	first := 0
+	if withContext {
+		first = 1
+	}
+	paramTypes := make([]reflect.Type, 0, t.NumIn())
+	for i := first; i < t.NumIn(); i++ {
+		vt, ok := valueTypeOf(t.In(i))
+		if !ok {
+			return nil, nil, fmt.Errorf("Unsupported parameter type %s in %s", t.In(i), t)
+		}
+		sig.Params = append(sig.Params, vt)
+		paramTypes = append(paramTypes, t.In(i))
+	}
+	var resultType reflect.Type
+	withError, withResult := false, false
+	switch t.NumOut() {
+	case 0:
+	case 1:
+		withError = t.Out(0) == errorType
+		withResult = !withError
+	case 2:
+		if t.Out(1) != errorType {
+			return nil, nil, fmt.Errorf("The second result must be an error in %s", t)
+		}
+		withResult, withError = true, true
+	default:
+		return nil, nil, fmt.Errorf("Host functions return at most one value and an error: %s", t)
+	}
+	if withResult {
+		vt, ok := valueTypeOf(t.Out(0))
+		if !ok {
+			return nil, nil, fmt.Errorf("Unsupported result type %s in %s", t.Out(0), t)
+		}
+		sig.Result = vt
+		// Named types are converted to the builtin type that toSlot expects
+		resultType = reflect.TypeOf(fromSlot(0, vt))
+		if t.Out(0).Kind() == reflect.Uint64 {
+			resultType = reflect.TypeOf(uint64(0))
+		}
+	}
+	wrapper := func(ctx *HostContext, args []interface{}) (interface{}, error) {
+		in := make([]reflect.Value, 0, len(args) + first)
+		if withContext {
+			in = append(in, reflect.ValueOf(ctx))
+		}
+		for i, arg := range args {
+			in = append(in, reflect.ValueOf(arg).Convert(paramTypes[i]))
+		}
+		out := v.Call(in)
+		var result interface{}
+		if withResult {
+			result = out[0].Convert(resultType).Interface()
+		}
+		if withError {
+			if err := out[len(out) - 1].Interface(); err != nil {
+				return nil, err.(error)
+			}
+		}
+		return result, nil
+	}
+	return sig, wrapper, nil
+}
+
+// RegisterHostFunc registers a Go function for the imports matching moduleName and fieldName.
+// The WASM signature is derived from the function type (see wrapHostFunc), e.g.
+// func(ctx *HostContext, x int32, y float64) int64 becomes "I(iF)".
+// The function is linked into the loaded modules that import it and the ones loaded afterwards.
+func(r *Runtime) RegisterHostFunc(moduleName, fieldName string, fn interface{}) error {
+	sig, wrapper, err := wrapHostFunc(fn)
+	if err != nil {
+		return err
+	}
+	hf := &registeredHostFunc{
+		moduleName: moduleName,
+		fieldName: fieldName,
+		signature: sig.String(),
+		fn: wrapper,
+	}
+	for _, m := range r.modules {
+		if err := hf.link(m); err != nil {
+			return err
+		}
+	}
+	r.hostFuncs = append(r.hostFuncs, hf)
+	return nil
+}
@@ -0,0 +1,227 @@
+package wasm3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func hostTestModule() *testModule {
+	m := &testModule{}
+	add := m.addImport(testImport{module: "env", field: "add", params: []byte{i64, f64}, results: []byte{f64}})
+	fail := m.addImport(testImport{module: "env", field: "fail", results: []byte{i32}})
+	m.addFunc(testFunc{export: "run", params: []byte{i64, f64}, results: []byte{f64},
+		code: []byte{0x20, 0, 0x20, 1, 0x10, byte(add)}})
+	m.addFunc(testFunc{export: "run_fail", results: []byte{i32},
+		code: []byte{0x10, byte(fail)}})
+	return m
+}
+
+func TestLinkFunction(t *testing.T) {
+	runtime := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	defer runtime.Destroy()
+	module, err := runtime.ParseModule(hostTestModule().bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(ctx *HostContext, args []interface{}) (interface{}, error) {
+		if ctx.Runtime != runtime {
+			t.Fatal("HostContext doesn't reference the runtime")
+		}
+		return float64(args[0].(int64)) + args[1].(float64), nil
+	}
+	if err := module.LinkFunction("env", "add", "F(IF)", add); err != errModuleNotLoaded {
+		t.Fatal("Linking functions into a module that isn't loaded should error")
+	}
+	if _, err := runtime.LoadModule(module); err != nil {
+		t.Fatal(err)
+	}
+	if err := module.LinkFunction("env", "add", "F(iF)", add); err == nil {
+		t.Fatal("Signature mismatch should error")
+	}
+	if err := module.LinkFunction("env", "missing", "v()", add); err == nil {
+		t.Fatal("Linking a function that isn't imported should error")
+	}
+	if err := module.LinkFunction("env", "add", "F(IF)", add); err != nil {
+		t.Fatal(err)
+	}
+	hostErr := errors.New("host failure")
+	fail := func(ctx *HostContext, args []interface{}) (interface{}, error) {
+		return nil, hostErr
+	}
+	if err := module.LinkFunction("*", "fail", "i()", fail); err != nil {
+		t.Fatal(err)
+	}
+
+	fn, err := runtime.FindFunction("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := fn.Call(int64(1)<<40, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != float64(1<<40)+0.5 {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	fn, err = runtime.FindFunction("run_fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fn.Call()
+	var trapErr *TrapError
+	if !errors.Is(err, hostErr) || !errors.As(err, &trapErr) {
+		t.Fatalf("Expected the host function error as a trap, got %v", err)
+	}
+	if e, ok := err.(*Error); !ok || e.Function != "run_fail" || e.Module == "" {
+		t.Fatalf("Expected the error details, got %#v", err)
+	}
+
+	panicking := func(ctx *HostContext, args []interface{}) (interface{}, error) {
+		panic("boom")
+	}
+	if err := module.LinkFunction("env", "fail", "i()", panicking); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn.Call(); !errors.As(err, &trapErr) || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Expected the host function panic to be returned, got %v", err)
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	valid := map[string]string{
+		"i(ii)":     "i(ii)",
+		"v()":       "v()",
+		"F(I f *)":  "F(Ifi)",
+		" I ( F ) ": "I(F)",
+	}
+	for in, out := range valid {
+		sig, err := ParseSignature(in)
+		if err != nil {
+			t.Fatalf("%q: %s", in, err)
+		}
+		if sig.String() != out {
+			t.Fatalf("%q: expected %q, got %q", in, out, sig)
+		}
+	}
+	for _, in := range []string{"", "i", "(i)", "i(v)", "ii(i)", "i(x)", "i(i))", "i(i"} {
+		if _, err := ParseSignature(in); err == nil {
+			t.Fatalf("%q should be invalid", in)
+		}
+	}
+}
+
+func TestRegisterHostFunc(t *testing.T) {
+	runtime := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	defer runtime.Destroy()
+
+	var gotX int64
+	var gotY float64
+	add := func(ctx *HostContext, x int64, y float64) float64 {
+		if ctx.Runtime != runtime {
+			t.Fatal("HostContext doesn't reference the runtime")
+		}
+		gotX, gotY = x, y
+		return float64(x) + y
+	}
+	mismatch := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	defer mismatch.Destroy()
+	if err := mismatch.RegisterHostFunc("env", "add", func(x int32, y float64) float64 { return 0 }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mismatch.Load(hostTestModule().bytes()); err == nil {
+		t.Fatal("Registered functions with a mismatching signature should error")
+	}
+
+	if err := runtime.RegisterHostFunc("env", "add", add); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.Load(hostTestModule().bytes()); err != nil {
+		t.Fatal(err)
+	}
+	// Registering after the module is loaded links it too
+	hostErr := errors.New("host failure")
+	if err := runtime.RegisterHostFunc("env", "fail", func() (uint32, error) {
+		return 0, hostErr
+	}); err != nil {
+		t.Fatal(err)
+	}
+	fn, err := runtime.FindFunction("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := fn.Call(int64(-3), 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != -2.75 || gotX != -3 || gotY != 0.25 {
+		t.Fatalf("Unexpected result: %v (x=%v, y=%v)", result, gotX, gotY)
+	}
+	fn, err = runtime.FindFunction("run_fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn.Call(); !errors.Is(err, hostErr) {
+		t.Fatalf("Expected the host function error, got %v", err)
+	}
+	if err := runtime.RegisterHostFunc("env", "fail", func() int64 { return 0 }); err == nil {
+		t.Fatal("Registering a function with a mismatching signature should error")
+	}
+}
+
+func TestWrapHostFunc(t *testing.T) {
+	type handle uint32
+	valid := map[string]interface{}{
+		"I(iF)": func(ctx *HostContext, x int32, y float64) int64 { return 0 },
+		"v()":   func() {},
+		"v(I)":  func(x uint64) error { return nil },
+		"f(fi)": func(x float32, h handle) (float32, error) { return 0, nil },
+		"i()":   func(ctx *HostContext) handle { return 0 },
+	}
+	for expected, fn := range valid {
+		sig, _, err := wrapHostFunc(fn)
+		if err != nil {
+			t.Fatalf("%s: %s", expected, err)
+		}
+		if sig.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, sig)
+		}
+	}
+	invalid := []interface{}{
+		42,
+		func(s string) {},
+		func(x int) {},
+		func(x ...int32) {},
+		func(x int32, ctx *HostContext) {},
+		func() (int32, int32) { return 0, 0 },
+		func() (error, int32) { return nil, 0 },
+		func() string { return "" },
+	}
+	for _, fn := range invalid {
+		if _, _, err := wrapHostFunc(fn); err == nil {
+			t.Fatalf("%T should be rejected", fn)
+		}
+	}
+
+	_, wrapper, err := wrapHostFunc(func(h handle, x uint64) handle { return h + handle(x) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := wrapper(nil, []interface{}{int32(-1), int64(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != int32(1) {
+		t.Fatalf("Unexpected result: %v (%T)", result, result)
+	}
+}
@@ -0,0 +1,83 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"context"
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+var(
+	// ErrInterrupted is returned when a call is interrupted, e.g. by CallContext
+	ErrInterrupted = m3Error(C.goErr_interrupted)
+
+	errExecHooksNotSupported = errors.New("Interrupting and metering calls isn't supported on this platform")
+)
+
+// interruptError wraps the reason of the interruption, e.g. context.DeadlineExceeded
+type interruptError struct {
+	cause error
+}
+
+func(e *interruptError) Error() string {
+	return ErrInterrupted.Error() + ": " + e.cause.Error()
+}
+
+// Is makes the error match ErrInterrupted besides the cause
+func(e *interruptError) Is(target error) bool {
+	return target == ErrInterrupted
+}
+
+// Unwrap returns the cause
+func(e *interruptError) Unwrap() error {
+	return e.cause
+}
+
+// CallContext works like Call but interrupts the guest once the context is done,
+// the returned error matches both ErrInterrupted and the context error (errors.Is).
+// The guest is checked on function entries and loop iterations, host functions aren't interrupted
+// except the WASI poll_oneoff sleeps on the system clock.
+func(f *Function) CallContext(ctx context.Context, args... interface{}) (interface{}, error) {
+	if ctx.Done() == nil {
+		return f.Call(args...)
+	}
+	if !execHooksSupported {
+		return nil, errExecHooksNotSupported
+	}
+	if f.runtime == nil {
+		return nil, errModuleNotLoaded
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, &TrapError{Err: &interruptError{cause: err}}
+	}
+	interrupted := (*int32)(unsafe.Pointer(&f.runtime.ctrl.interrupted))
+	interrupt := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(interrupted, 1)
+			close(interrupt)
+		case <-stop:
+		}
+	}()
+	f.runtime.interrupt = interrupt
+	result, err := f.Call(args...)
+	f.runtime.interrupt = nil
+	close(stop)
+	<-done
+	atomic.StoreInt32(interrupted, 0)
+
+	var trapErr *TrapError
+	if errors.As(err, &trapErr) && trapErr.Err == ErrInterrupted {
+		trapErr.Err = &interruptError{cause: ctx.Err()}
+	}
+	return result, err
+}
@@ -0,0 +1,109 @@
+package wasm3
+
+/*
+#cgo LDFLAGS: -Wl,--wrap=EmitOp
+#include "go-wasm3.h"
+#include "m3_compile.h"
+
+// The compiler emits op_Entry and op_Loop through EmitOp, it's wrapped with the linker to
+// emit go_op_Entry and go_op_Loop instead, followed by the weight of the block: the number
+// of ops of the function outside of loops, or of the loop body outside of nested loops.
+// They check the control block and charge the weight on every entry and iteration.
+
+m3ret_t vectorcall op_Entry(d_m3OpSig);
+m3ret_t vectorcall op_Loop(d_m3OpSig);
+M3Result __real_EmitOp(IM3Compilation o, IM3Operation op);
+pc_t GetPC(IM3Compilation o);
+
+// go_check interrupts the call or charges the weight of the block
+static inline M3Result go_check(uintptr_t weight) {
+	go_call_ctrl* ctrl = go_current_ctrl;
+	if (ctrl == NULL) {
+		return m3Err_none;
+	}
+	if (__atomic_load_n(&ctrl->interrupted, __ATOMIC_RELAXED)) {
+		return goErr_interrupted;
+	}
+	if (ctrl->metered) {
+		if (ctrl->fuel < weight) {
+			ctrl->fuel = 0;
+			return goErr_outOfFuel;
+		}
+		ctrl->fuel -= weight;
+	}
+	return m3Err_none;
+}
+
+static m3ret_t vectorcall go_op_Entry(d_m3OpSig) {
+	uintptr_t weight = (uintptr_t)(* _pc++);
+	M3Result result = go_check(weight);
+	if (result) {
+		return (m3ret_t) result;
+	}
+	return op_Entry(_pc, d_m3OpArgs);
+}
+
+// op_Loop runs every iteration of the loop so it's reimplemented here
+static m3ret_t vectorcall go_op_Loop(d_m3OpSig) {
+	uintptr_t weight = (uintptr_t)(* _pc++);
+	IM3Runtime runtime = _mem->runtime;
+	m3ret_t r;
+	do {
+		M3Result result = go_check(weight);
+		if (result) {
+			return (m3ret_t) result;
+		}
+		r = ((IM3Operation)(* _pc))(_pc + 1, d_m3OpArgs);
+		_mem = runtime->memory.mallocated;
+	} while (r == _pc);
+	return r;
+}
+
+// The weight of the function being compiled, compilations are on the stack so the
+// function tells them apart
+static __thread IM3Function go_entry_function;
+static __thread code_t* go_entry_weight;
+
+// go_weight returns the weight of the innermost loop, or of the function
+static code_t* go_weight(IM3Compilation o) {
+	for (IM3CompilationScope scope = &o->block; scope != NULL; scope = scope->outer) {
+		if (scope->opcode == 0x03) {
+			// The loop body starts after its weight
+			return (code_t*) scope->pc - 1;
+		}
+	}
+	if (o->function == go_entry_function) {
+		return go_entry_weight;
+	}
+	return NULL;
+}
+
+M3Result __wrap_EmitOp(IM3Compilation o, IM3Operation op) {
+	// There's no page when the compiler only walks the code
+	if (o->page == NULL) {
+		return __real_EmitOp(o, op);
+	}
+	code_t* weight = go_weight(o);
+	if (weight != NULL) {
+		*weight = (code_t)((uintptr_t)(* weight) + 1);
+	}
+	if (op != op_Entry && op != op_Loop) {
+		return __real_EmitOp(o, op);
+	}
+	M3Result result = __real_EmitOp(o, op == op_Entry ? go_op_Entry : go_op_Loop);
+	if (result) {
+		return result;
+	}
+	if (op == op_Entry) {
+		go_entry_function = o->function;
+		go_entry_weight = (code_t*) GetPC(o);
+	}
+	// The op itself is charged, so the weight starts at one
+	EmitPointer(o, (void*) 1);
+	return m3Err_none;
+}
+*/
+import "C"
+
+// execHooksSupported is true when op_Entry and op_Loop are replaced
+const execHooksSupported = true
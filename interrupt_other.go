@@ -0,0 +1,6 @@
+// +build !linux
+
+package wasm3
+
+// execHooksSupported is false when the linker can't wrap EmitOp to replace op_Entry and op_Loop
+const execHooksSupported = false
@@ -0,0 +1,84 @@
+package wasm3
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func interruptTestModule() *testModule {
+	m := &testModule{}
+	m.addFunc(testFunc{export: "spin", code: []byte{0x03, 0x40, 0x0c, 0x00, 0x0b}})
+	// ping calls pong and pong calls ping, each in a loop without iterations
+	ping := uint32(2)
+	pong := m.addFunc(testFunc{export: "pong", params: []byte{i32}, results: []byte{i32},
+		code: []byte{0x20, 0x00, 0x10, byte(ping)}})
+	m.addFunc(testFunc{export: "ping", params: []byte{i32}, results: []byte{i32},
+		code: []byte{0x20, 0x00, 0x41, 0x00, 0x4a, 0x04, 0x7f, 0x20, 0x00, 0x41, 0x01, 0x6b, 0x10, byte(pong), 0x05, 0x41, 0x00, 0x0b}})
+	return m
+}
+
+func TestCallContext(t *testing.T) {
+	if !execHooksSupported {
+		t.Skip("Interrupting calls isn't supported on this platform")
+	}
+	runtime := loadTestModule(t, interruptTestModule())
+	defer runtime.Destroy()
+
+	spin, err := runtime.FindFunction("spin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = spin.CallContext(ctx)
+	if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected an interrupted error, got %v", err)
+	}
+	var trapErr *TrapError
+	if !errors.As(err, &trapErr) {
+		t.Fatalf("Expected a *TrapError, got %T", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := spin.CallContext(ctx); !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a canceled error, got %v", err)
+	}
+	if _, err := spin.CallContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Calls with a canceled context shouldn't run, got %v", err)
+	}
+
+	// The runtime is usable after an interruption
+	ping, err := runtime.FindFunction("ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ping.CallContext(context.Background(), 10)
+	if err != nil || result != int32(0) {
+		t.Fatalf("Unexpected result: %v, %v", result, err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err = ping.CallContext(ctx, 100)
+	if err != nil || result != int32(0) {
+		t.Fatalf("Unexpected result: %v, %v", result, err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := ping.CallContext(ctx, 1); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Expected an interrupted error, got %v", err)
+	}
+
+	// Function entries are checked too
+	runtime.ctrl.interrupted = 1
+	_, err = ping.Call(1)
+	runtime.ctrl.interrupted = 0
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Expected an interrupted error, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var(
+	// ErrMemoryOutOfRange is returned when an access falls outside of the guest memory
+	ErrMemoryOutOfRange = errors.New("Memory access out of range")
+	// ErrMemoryLimitExceeded is returned when the memory would grow above Config.MaxMemoryPages
+	ErrMemoryLimitExceeded = errors.New("Memory limit exceeded")
+)
+
+const(
+	// PageSize is the size of a WASM memory page
+	PageSize = 65536
+	// maxMemoryPages is the 4GiB WASM limit
+	maxMemoryPages = 65536
+)
+
+// MemoryLimitError is returned when a module or a grow needs more pages than Config.MaxMemoryPages.
+// When the guest memory.grow fails (it gets -1) and the call traps afterwards,
+// Err holds the trap.
+type MemoryLimitError struct {
+	Requested uint32
+	Max uint32
+	Err error
+}
+
+func(e *MemoryLimitError) Error() string {
+	msg := fmt.Sprintf("%s: %d pages requested, the limit is %d", ErrMemoryLimitExceeded, e.Requested, e.Max)
+	if e.Err != nil {
+		msg += " (" + e.Err.Error() + ")"
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrMemoryLimitExceeded) work
+func(e *MemoryLimitError) Is(target error) bool {
+	return target == ErrMemoryLimitExceeded
+}
+
+func(e *MemoryLimitError) Unwrap() error {
+	return e.Err
+}
+
+// limitMemory applies Config.MaxMemoryPages to the module memory before it's loaded
+func(r *Runtime) limitMemory(module *Module) error {
+	max := C.u32(r.cfg.MaxMemoryPages)
+	info := &module.Ptr().memoryInfo
+	if max == 0 || module.Ptr().memoryImported {
+		return nil
+	}
+	if info.initPages > max {
+		return &MemoryLimitError{Requested: uint32(info.initPages), Max: uint32(max)}
+	}
+	if info.maxPages == 0 || info.maxPages > max {
+		info.maxPages = max
+	}
+	return nil
+}
+
+// MemoryPages returns the memory size in pages
+func(r *Runtime) MemoryPages() uint32 {
+	return uint32(r.Ptr().memory.numPages)
+}
+
+// GrowMemory grows the memory by pages and returns the previous size like memory.grow.
+// It fails with a *MemoryLimitError above Config.MaxMemoryPages.
+func(r *Runtime) GrowMemory(pages uint32) (uint32, error) {
+	current := r.MemoryPages()
+	requested := uint64(current) + uint64(pages)
+	if requested > maxMemoryPages {
+		return 0, ErrWasmMemoryOverflow
+	}
+	if max := r.cfg.MaxMemoryPages; max > 0 && requested > uint64(max) {
+		return 0, &MemoryLimitError{Requested: uint32(requested), Max: max}
+	}
+	if result := C.ResizeMemory(r.Ptr(), C.u32(requested)); result != nil {
+		return 0, newError(result, kindAny)
+	}
+	return current, nil
+}
+
+// Memory gives bounds-checked access to the guest memory, values are little-endian as WASM requires.
+// Pointers are offsets into the guest memory.
+// The guest may grow (and move) its memory, so every access resolves the current base and length.
+type Memory struct {
+	runtime *Runtime
+	// MaxStringLength limits the size in bytes of the strings read and written
+	// by the string helpers, DefaultMaxStringLength is used when it's 0
+	MaxStringLength uint32
+}
+
+// Size returns the memory size in bytes
+func(m *Memory) Size() uint32 {
+	return uint32(m.runtime.GetAllocatedMemoryLength())
+}
+
+// Bytes returns the memory without bounds checks.
+// The slice must not be used after the guest runs again, it's invalid once the memory grows.
+func(m *Memory) Bytes() []byte {
+	return m.runtime.memoryBytes()
+}
+
+// slice returns length bytes starting at ptr
+func(m *Memory) slice(ptr, length uint32) ([]byte, error) {
+	data := m.runtime.memoryBytes()
+	if uint64(ptr) + uint64(length) > uint64(len(data)) {
+		return nil, fmt.Errorf("%w: %d bytes at %d, memory size is %d", ErrMemoryOutOfRange, length, ptr, len(data))
+	}
+	return data[ptr:ptr + length:ptr + length], nil
+}
+
+// Read returns a copy of length bytes starting at ptr
+func(m *Memory) Read(ptr, length uint32) ([]byte, error) {
+	b, err := m.slice(ptr, length)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// Write copies data into the memory starting at ptr
+func(m *Memory) Write(ptr uint32, data []byte) error {
+	b, err := m.slice(ptr, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	copy(b, data)
+	return nil
+}
+
+// ReadUint8 reads a byte
+func(m *Memory) ReadUint8(ptr uint32) (uint8, error) {
+	b, err := m.slice(ptr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ReadUint16 reads a little-endian uint16
+func(m *Memory) ReadUint16(ptr uint32) (uint16, error) {
+	b, err := m.slice(ptr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+// ReadUint32 reads a little-endian uint32
+func(m *Memory) ReadUint32(ptr uint32) (uint32, error) {
+	b, err := m.slice(ptr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// ReadUint64 reads a little-endian uint64
+func(m *Memory) ReadUint64(ptr uint32) (uint64, error) {
+	b, err := m.slice(ptr, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// ReadFloat32 reads a little-endian IEEE 754 float32
+func(m *Memory) ReadFloat32(ptr uint32) (float32, error) {
+	v, err := m.ReadUint32(ptr)
+	return math.Float32frombits(v), err
+}
+
+// ReadFloat64 reads a little-endian IEEE 754 float64
+func(m *Memory) ReadFloat64(ptr uint32) (float64, error) {
+	v, err := m.ReadUint64(ptr)
+	return math.Float64frombits(v), err
+}
+
+// WriteUint8 writes a byte
+func(m *Memory) WriteUint8(ptr uint32, v uint8) error {
+	b, err := m.slice(ptr, 1)
+	if err != nil {
+		return err
+	}
+	b[0] = v
+	return nil
+}
+
+// WriteUint16 writes a little-endian uint16
+func(m *Memory) WriteUint16(ptr uint32, v uint16) error {
+	b, err := m.slice(ptr, 2)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(b, v)
+	return nil
+}
+
+// WriteUint32 writes a little-endian uint32
+func(m *Memory) WriteUint32(ptr uint32, v uint32) error {
+	b, err := m.slice(ptr, 4)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b, v)
+	return nil
+}
+
+// WriteUint64 writes a little-endian uint64
+func(m *Memory) WriteUint64(ptr uint32, v uint64) error {
+	b, err := m.slice(ptr, 8)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(b, v)
+	return nil
+}
+
+// WriteFloat32 writes a little-endian IEEE 754 float32
+func(m *Memory) WriteFloat32(ptr uint32, v float32) error {
+	return m.WriteUint32(ptr, math.Float32bits(v))
+}
+
+// WriteFloat64 writes a little-endian IEEE 754 float64
+func(m *Memory) WriteFloat64(ptr uint32, v float64) error {
+	return m.WriteUint64(ptr, math.Float64bits(v))
+}
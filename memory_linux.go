@@ -0,0 +1,24 @@
+package wasm3
+
+/*
+#cgo LDFLAGS: -Wl,--wrap=ResizeMemory
+#include "go-wasm3.h"
+
+// ResizeMemory is wrapped to tell when a guest memory.grow fails because of
+// Config.MaxMemoryPages, the guest only gets -1.
+
+M3Result __real_ResizeMemory(IM3Runtime, u32);
+
+M3Result __wrap_ResizeMemory(IM3Runtime io_runtime, u32 i_numPages) {
+	M3Result result = __real_ResizeMemory(io_runtime, i_numPages);
+	go_call_ctrl* ctrl = go_current_ctrl;
+	if (result == m3Err_wasmMemoryOverflow && ctrl != NULL && ctrl->maxPages && i_numPages > ctrl->maxPages) {
+		ctrl->deniedPages = i_numPages;
+	}
+	return result;
+}
+*/
+import "C"
+
+// growHookSupported is true when failed guest grows are detected
+const growHookSupported = true
@@ -0,0 +1,6 @@
+// +build !linux
+
+package wasm3
+
+// growHookSupported is false when the linker can't wrap ResizeMemory
+const growHookSupported = false
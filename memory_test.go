@@ -0,0 +1,244 @@
+package wasm3
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func memoryTestModule() *testModule {
+	m := &testModule{memory: &testMemory{min: 1, max: 4, hasMax: true}}
+	m.addFunc(testFunc{export: "load32", params: []byte{i32}, results: []byte{i32},
+		code: []byte{0x20, 0x00, 0x28, 0x02, 0x00}})
+	m.addFunc(testFunc{export: "load64", params: []byte{i32}, results: []byte{i64},
+		code: []byte{0x20, 0x00, 0x29, 0x03, 0x00}})
+	m.addFunc(testFunc{export: "loadf64", params: []byte{i32}, results: []byte{f64},
+		code: []byte{0x20, 0x00, 0x2b, 0x03, 0x00}})
+	m.addFunc(testFunc{export: "store32", params: []byte{i32, i32},
+		code: []byte{0x20, 0x00, 0x20, 0x01, 0x36, 0x02, 0x00}})
+	// grow adds pages and returns the previous size
+	m.addFunc(testFunc{export: "grow", params: []byte{i32}, results: []byte{i32},
+		code: []byte{0x20, 0x00, 0x40, 0x00}})
+	return m
+}
+
+func callFunction(t *testing.T, runtime *Runtime, name string, args ...interface{}) interface{} {
+	t.Helper()
+	fn, err := runtime.FindFunction(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := fn.Call(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestMemory(t *testing.T) {
+	runtime := loadTestModule(t, memoryTestModule())
+	defer runtime.Destroy()
+
+	mem := runtime.Memory()
+	if mem.Size() != 65536 || len(mem.Bytes()) != 65536 {
+		t.Fatalf("Unexpected memory size: %d", mem.Size())
+	}
+	if err := mem.WriteUint32(16, 0xdeadbeef); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "load32", 16); v != int32(-559038737) {
+		t.Fatalf("The guest read %v", v)
+	}
+	if err := mem.WriteUint64(24, 1<<40|7); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "load64", 24); v != int64(1<<40|7) {
+		t.Fatalf("The guest read %v", v)
+	}
+	if err := mem.WriteFloat64(32, 2.5); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "loadf64", 32); v != 2.5 {
+		t.Fatalf("The guest read %v", v)
+	}
+	callFunction(t, runtime, "store32", 40, 0x01020304)
+	if v, err := mem.ReadUint32(40); err != nil || v != 0x01020304 {
+		t.Fatalf("Unexpected value: %x, %v", v, err)
+	}
+	if v, err := mem.ReadUint16(40); err != nil || v != 0x0304 {
+		t.Fatalf("Unexpected value: %x, %v", v, err)
+	}
+	if v, err := mem.ReadUint8(43); err != nil || v != 0x01 {
+		t.Fatalf("Unexpected value: %x, %v", v, err)
+	}
+	if err := mem.WriteUint16(50, 0xabcd); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.WriteUint8(52, 0xef); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.WriteFloat32(56, 1.25); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadFloat32(56); err != nil || v != 1.25 {
+		t.Fatalf("Unexpected value: %v, %v", v, err)
+	}
+	if v, err := mem.ReadFloat64(32); err != nil || v != 2.5 {
+		t.Fatalf("Unexpected value: %v, %v", v, err)
+	}
+	if v, err := mem.ReadUint64(24); err != nil || v != 1<<40|7 {
+		t.Fatalf("Unexpected value: %v, %v", v, err)
+	}
+
+	if err := mem.Write(65530, []byte("hello!")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := mem.Read(65530, 6)
+	if err != nil || string(data) != "hello!" {
+		t.Fatalf("Unexpected data: %q, %v", data, err)
+	}
+	// Read returns a copy
+	data[0] = 'j'
+	if data, _ := mem.Read(65530, 1); !bytes.Equal(data, []byte("h")) {
+		t.Fatal("Read must return a copy")
+	}
+}
+
+func TestMemoryOutOfRange(t *testing.T) {
+	runtime := loadTestModule(t, memoryTestModule())
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	checks := map[string]error{
+		"Read":        func() error { _, err := mem.Read(65530, 7); return err }(),
+		"Read max":    func() error { _, err := mem.Read(0xffffffff, 2); return err }(),
+		"Write":       mem.Write(65535, []byte{1, 2}),
+		"ReadUint8":   func() error { _, err := mem.ReadUint8(65536); return err }(),
+		"ReadUint16":  func() error { _, err := mem.ReadUint16(65535); return err }(),
+		"ReadUint32":  func() error { _, err := mem.ReadUint32(65533); return err }(),
+		"ReadUint64":  func() error { _, err := mem.ReadUint64(65529); return err }(),
+		"ReadFloat32": func() error { _, err := mem.ReadFloat32(0xfffffffe); return err }(),
+		"ReadFloat64": func() error { _, err := mem.ReadFloat64(65535); return err }(),
+		"WriteUint8":  mem.WriteUint8(65536, 1),
+		"WriteUint16": mem.WriteUint16(65535, 1),
+		"WriteUint32": mem.WriteUint32(65533, 1),
+		"WriteUint64": mem.WriteUint64(65529, 1),
+		"WriteFloat":  mem.WriteFloat64(0xffffffff, 1),
+	}
+	for name, err := range checks {
+		if !errors.Is(err, ErrMemoryOutOfRange) {
+			t.Fatalf("%s: expected an out of range error, got %v", name, err)
+		}
+	}
+	if _, err := mem.Read(65536, 0); err != nil {
+		t.Fatalf("Empty reads at the end of the memory are valid: %v", err)
+	}
+}
+
+func TestMemoryGrowth(t *testing.T) {
+	runtime := loadTestModule(t, memoryTestModule())
+	defer runtime.Destroy()
+
+	mem := runtime.Memory()
+	if err := mem.WriteUint32(8, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.WriteUint32(65536, 1); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+	if pages := callFunction(t, runtime, "grow", 2); pages != int32(1) {
+		t.Fatalf("Unexpected previous size: %v", pages)
+	}
+	// The handle follows the memory after it grows
+	if mem.Size() != 3*65536 {
+		t.Fatalf("Unexpected memory size: %d", mem.Size())
+	}
+	if v, err := mem.ReadUint32(8); err != nil || v != 42 {
+		t.Fatalf("Unexpected value: %v, %v", v, err)
+	}
+	if err := mem.WriteUint32(2*65536+4, 7); err != nil {
+		t.Fatal(err)
+	}
+	if v := callFunction(t, runtime, "load32", 2*65536+4); v != int32(7) {
+		t.Fatalf("The guest read %v", v)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	m := memoryTestModule()
+	m.memory = &testMemory{min: 1}
+	// grow_or_trap traps when memory.grow fails, like guests that abort on OOM
+	m.addFunc(testFunc{export: "grow_or_trap", params: []byte{i32},
+		code: []byte{0x20, 0, 0x40, 0x00, 0x41, 0x7f, 0x46, 0x04, 0x40, 0x00, 0x0b}})
+	newRuntime := func() *Runtime {
+		return NewRuntime(&Config{
+			Environment:    NewEnvironment(),
+			StackSize:      64 * 1024,
+			MaxMemoryPages: 3,
+		})
+	}
+	runtime := newRuntime()
+	defer runtime.Destroy()
+	if _, err := runtime.Load(m.bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if pages := runtime.MemoryPages(); pages != 1 {
+		t.Fatalf("Unexpected number of pages: %d", pages)
+	}
+	if prev, err := runtime.GrowMemory(1); err != nil || prev != 1 {
+		t.Fatalf("Unexpected result: %d, %v", prev, err)
+	}
+	if runtime.MemoryPages() != 2 || runtime.Memory().Size() != 2*PageSize {
+		t.Fatalf("Unexpected memory size: %d", runtime.Memory().Size())
+	}
+	_, err := runtime.GrowMemory(2)
+	var limitErr *MemoryLimitError
+	if !errors.As(err, &limitErr) || limitErr.Requested != 4 || limitErr.Max != 3 {
+		t.Fatalf("Expected a *MemoryLimitError, got %v", err)
+	}
+	if !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("Expected ErrMemoryLimitExceeded, got %v", err)
+	}
+
+	// The module doesn't declare a maximum, the guest is capped too
+	if prev := callFunction(t, runtime, "grow", 1); prev != int32(2) {
+		t.Fatalf("Unexpected previous size: %v", prev)
+	}
+	if prev := callFunction(t, runtime, "grow", 1); prev != int32(-1) {
+		t.Fatalf("The grow should fail, got %v", prev)
+	}
+	fn, err := runtime.FindFunction("grow_or_trap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fn.Call(1)
+	if !errors.Is(err, ErrTrapUnreachable) {
+		t.Fatalf("Expected the trap, got %v", err)
+	}
+	if growHookSupported && !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("Expected ErrMemoryLimitExceeded, got %v", err)
+	}
+	if runtime.MemoryPages() != 3 {
+		t.Fatalf("Unexpected number of pages: %d", runtime.MemoryPages())
+	}
+
+	big := newRuntime()
+	defer big.Destroy()
+	m.memory = &testMemory{min: 4}
+	if _, err := big.Load(m.bytes()); !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Fatalf("Expected ErrMemoryLimitExceeded, got %v", err)
+	}
+}
+
+func TestMemoryWithoutMemory(t *testing.T) {
+	runtime := loadTestModule(t, &testModule{funcs: []testFunc{{export: "f"}}})
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+	if mem.Size() != 0 || mem.Bytes() != nil {
+		t.Fatalf("Unexpected memory size: %d", mem.Size())
+	}
+	if _, err := mem.ReadUint8(0); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"unsafe"
+)
+
+// ExternKind is the kind of an import or export
+type ExternKind uint8
+
+const(
+	// ExternFunction is a function import or export
+	ExternFunction ExternKind = 0
+	// ExternTable is a table import or export
+	ExternTable ExternKind = 1
+	// ExternMemory is a memory import or export
+	ExternMemory ExternKind = 2
+	// ExternGlobal is a global import or export
+	ExternGlobal ExternKind = 3
+)
+
+// String returns the name of the kind
+func(k ExternKind) String() string {
+	switch k {
+	case ExternFunction:
+		return "function"
+	case ExternTable:
+		return "table"
+	case ExternMemory:
+		return "memory"
+	case ExternGlobal:
+		return "global"
+	}
+	return "unknown"
+}
+
+// GlobalType describes a global
+type GlobalType struct {
+	Type ValueType
+	Mutable bool
+}
+
+// MemoryType describes the memory limits, in pages.
+// MaxPages is 0 when the module doesn't declare a maximum.
+type MemoryType struct {
+	MinPages uint32
+	MaxPages uint32
+}
+
+// ExternType describes an import or export, only the field matching Kind is set
+type ExternType struct {
+	Kind ExternKind
+	Signature *Signature
+	Global *GlobalType
+	Memory *MemoryType
+}
+
+// Import describes a module import
+type Import struct {
+	Module string
+	Field string
+	ExternType
+}
+
+// String returns the import name followed by its type, e.g. "env.log I(iF)"
+func(i Import) String() string {
+	name := i.Module + "." + i.Field
+	switch {
+	case i.Signature != nil:
+		return name + " " + i.Signature.String()
+	case i.Global != nil:
+		return name + " global " + i.Global.Type.String()
+	}
+	return name + " " + i.Kind.String()
+}
+
+// Export describes a module export
+type Export struct {
+	Name string
+	ExternType
+}
+
+// Imports returns the module imports in declaration order
+func(m *Module) Imports() []Import {
+	if m.sections == nil {
+		return m.importsFromModule()
+	}
+	var imports []Import
+	var numFunctions, numGlobals uint32
+	for _, imp := range m.sections.imports {
+		var index uint32
+		switch imp.kind {
+		case ExternFunction:
+			index = numFunctions
+			numFunctions++
+		case ExternGlobal:
+			index = numGlobals
+			numGlobals++
+		}
+		imports = append(imports, Import{
+			Module: imp.module,
+			Field: imp.field,
+			ExternType: m.externType(imp.kind, index),
+		})
+	}
+	return imports
+}
+
+// Exports returns the module exports in declaration order
+func(m *Module) Exports() []Export {
+	if m.sections == nil {
+		return m.exportsFromModule()
+	}
+	var exports []Export
+	for _, exp := range m.sections.exports {
+		exports = append(exports, Export{
+			Name: exp.name,
+			ExternType: m.externType(exp.kind, exp.index),
+		})
+	}
+	return exports
+}
+
+// externType resolves the type of a function or global index
+func(m *Module) externType(kind ExternKind, index uint32) ExternType {
+	t := ExternType{Kind: kind}
+	module := m.Ptr()
+	switch kind {
+	case ExternFunction:
+		if index < uint32(module.numFunctions) {
+			t.Signature = newSignature(C.module_get_function(module, C.int(index)).funcType)
+		}
+	case ExternGlobal:
+		if index < uint32(module.numGlobals) {
+			t.Global = newGlobalType(moduleGlobal(module, index))
+		}
+	case ExternMemory:
+		t.Memory = &MemoryType{
+			MinPages: uint32(module.memoryInfo.initPages),
+			MaxPages: uint32(module.memoryInfo.maxPages),
+		}
+	}
+	return t
+}
+
+// importsFromModule lists the function and global imports known to WASM3, it's used for modules
+// created without the WASM binary (NewModule).
+func(m *Module) importsFromModule() []Import {
+	var imports []Import
+	module := m.Ptr()
+	for i := 0; i < m.NumFunctions(); i++ {
+		f := C.module_get_function(module, C.int(i))
+		if f._import.moduleUtf8 == nil || f._import.fieldUtf8 == nil {
+			continue
+		}
+		imports = append(imports, Import{
+			Module: C.GoString(f._import.moduleUtf8),
+			Field: C.GoString(f._import.fieldUtf8),
+			ExternType: m.externType(ExternFunction, uint32(i)),
+		})
+	}
+	for i := uint32(0); i < uint32(module.numGlobals); i++ {
+		g := moduleGlobal(module, i)
+		if !bool(g.imported) || g._import.moduleUtf8 == nil || g._import.fieldUtf8 == nil {
+			continue
+		}
+		imports = append(imports, Import{
+			Module: C.GoString(g._import.moduleUtf8),
+			Field: C.GoString(g._import.fieldUtf8),
+			ExternType: m.externType(ExternGlobal, i),
+		})
+	}
+	return imports
+}
+
+// exportsFromModule lists the named functions, WASM3 doesn't keep the names of other exports
+func(m *Module) exportsFromModule() []Export {
+	var exports []Export
+	for i := 0; i < m.NumFunctions(); i++ {
+		f := C.module_get_function(m.Ptr(), C.int(i))
+		if f.name == nil || f._import.fieldUtf8 != nil {
+			continue
+		}
+		exports = append(exports, Export{
+			Name: C.GoString(f.name),
+			ExternType: m.externType(ExternFunction, uint32(i)),
+		})
+	}
+	return exports
+}
+
+// The function imports linked by m3_LinkSpecTest, and by m3_LinkWASI when EnableWASI is set
+var(
+	specTestImports = map[string][]string{
+		"spectest": {"print", "print_i32", "print_i64", "print_f32", "print_f64", "print_i32_f32", "print_i64_f64"},
+		"wasm3": {"raw_sum"},
+	}
+	wasiUnstableImports = []string{
+		"args_get", "args_sizes_get", "environ_get", "environ_sizes_get", "fd_prestat_get", "fd_prestat_dir_name",
+		"path_open", "fd_fdstat_get", "fd_fdstat_set_flags", "fd_write", "fd_read", "fd_seek", "fd_datasync",
+		"fd_close", "random_get", "clock_res_get", "clock_time_get", "proc_exit",
+	}
+)
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// links reports whether LoadModule links the function import
+func(r *Runtime) links(moduleName, fieldName string) bool {
+	for _, hf := range r.hostFuncs {
+		if hf.fieldName == fieldName && (hf.moduleName == "*" || hf.moduleName == moduleName) {
+			return true
+		}
+	}
+	if r.cfg.EnableWASI && moduleName == wasiUnstableModuleName && containsString(wasiUnstableImports, fieldName) {
+		return true
+	}
+	return containsString(specTestImports[moduleName], fieldName)
+}
+
+// unresolvedImports returns the function imports that LoadModule won't link, it's used
+// before loading so rejected modules don't touch the runtime
+func(r *Runtime) unresolvedImports(m *Module) []Import {
+	var missing []Import
+	for i := 0; i < m.NumFunctions(); i++ {
+		f := C.module_get_function(m.Ptr(), C.int(i))
+		if f._import.moduleUtf8 == nil || f._import.fieldUtf8 == nil {
+			continue
+		}
+		imp := Import{
+			Module: C.GoString(f._import.moduleUtf8),
+			Field: C.GoString(f._import.fieldUtf8),
+			ExternType: m.externType(ExternFunction, uint32(i)),
+		}
+		if !r.links(imp.Module, imp.Field) {
+			missing = append(missing, imp)
+		}
+	}
+	return missing
+}
+
+// moduleGlobal returns the global at the given index
+func moduleGlobal(module C.IM3Module, index uint32) C.IM3Global {
+	return (C.IM3Global)(unsafe.Pointer(uintptr(unsafe.Pointer(module.globals)) + uintptr(index) * C.sizeof_M3Global))
+}
+
+func newGlobalType(g C.IM3Global) *GlobalType {
+	return &GlobalType{
+		Type: ValueType(g._type),
+		Mutable: bool(g.isMutable),
+	}
+}
@@ -0,0 +1,133 @@
+package wasm3
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestModuleImportsExports(t *testing.T) {
+	m := &testModule{}
+	m.addImport(testImport{module: "env", field: "log", params: []byte{i32, f64}, results: []byte{i64}})
+	m.addImport(testImport{module: "env", field: "base", global: &testGlobal{typ: i32}})
+	m.addImport(testImport{module: "env", field: "memory", memory: &testMemory{min: 2, max: 4, hasMax: true}})
+	m.addImport(testImport{module: "wasi_unstable", field: "proc_exit", params: []byte{i32}})
+	m.addFunc(testFunc{export: "run", params: []byte{f32}, results: []byte{i32}, code: []byte{0x41, 0x00}})
+	m.addGlobal(testGlobal{typ: i64, mutable: true, init: []byte{0x42, 0x07}, export: "counter"})
+
+	env := NewEnvironment()
+	defer env.Destroy()
+	module, err := env.ParseModule(m.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imports := module.Imports()
+	expectedImports := []Import{
+		{Module: "env", Field: "log", ExternType: ExternType{Kind: ExternFunction, Signature: &Signature{Params: []ValueType{TypeI32, TypeF64}, Result: TypeI64}}},
+		{Module: "env", Field: "base", ExternType: ExternType{Kind: ExternGlobal, Global: &GlobalType{Type: TypeI32}}},
+		{Module: "env", Field: "memory", ExternType: ExternType{Kind: ExternMemory, Memory: &MemoryType{MinPages: 2, MaxPages: 4}}},
+		{Module: "wasi_unstable", Field: "proc_exit", ExternType: ExternType{Kind: ExternFunction, Signature: &Signature{Params: []ValueType{TypeI32}}}},
+	}
+	if !reflect.DeepEqual(imports, expectedImports) {
+		t.Fatalf("Unexpected imports: %+v", imports)
+	}
+
+	exports := module.Exports()
+	expectedExports := []Export{
+		{Name: "run", ExternType: ExternType{Kind: ExternFunction, Signature: &Signature{Params: []ValueType{TypeF32}, Result: TypeI32}}},
+		{Name: "counter", ExternType: ExternType{Kind: ExternGlobal, Global: &GlobalType{Type: TypeI64, Mutable: true}}},
+	}
+	if !reflect.DeepEqual(exports, expectedExports) {
+		t.Fatalf("Unexpected exports: %+v", exports)
+	}
+
+	// Modules created without the binary fall back to what WASM3 keeps
+	wrapped := NewModule(module.ptr)
+	if len(wrapped.Imports()) != 3 || wrapped.Imports()[2].Field != "base" {
+		t.Fatalf("Unexpected imports: %+v", wrapped.Imports())
+	}
+	if exports := wrapped.Exports(); len(exports) != 1 || exports[0].Name != "run" {
+		t.Fatalf("Unexpected exports: %+v", exports)
+	}
+}
+
+func TestModuleMemoryExport(t *testing.T) {
+	m := &testModule{memory: &testMemory{min: 1}}
+	m.addFunc(testFunc{export: "f"})
+	env := NewEnvironment()
+	defer env.Destroy()
+	module, err := env.ParseModule(m.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports := module.Exports()
+	if len(exports) != 2 || exports[1].Name != "memory" || exports[1].Kind != ExternMemory || *exports[1].Memory != (MemoryType{MinPages: 1}) {
+		t.Fatalf("Unexpected exports: %+v", exports)
+	}
+	if ExternMemory.String() != "memory" {
+		t.Fatal("Unexpected kind name")
+	}
+}
+
+func TestStrictImports(t *testing.T) {
+	runtime := NewRuntime(&Config{
+		Environment:   NewEnvironment(),
+		StackSize:     64 * 1024,
+		StrictImports: true,
+	})
+	defer runtime.Destroy()
+	if err := runtime.RegisterHostFunc("env", "fail", func() int32 { return 1 }); err != nil {
+		t.Fatal(err)
+	}
+	m := hostTestModule()
+	m.addImport(testImport{module: "env", field: "log", params: []byte{i32}})
+	module, err := runtime.ParseModule(m.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = runtime.LoadModule(module)
+	var linkErr *LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(err, ErrFunctionImportMissing) {
+		t.Fatalf("Expected a *LinkError, got %v", err)
+	}
+	if len(linkErr.Missing) != 2 || linkErr.Missing[0].String() != "env.add F(IF)" || linkErr.Missing[1].String() != "env.log v(i)" {
+		t.Fatalf("Unexpected missing imports: %v", linkErr.Missing)
+	}
+	if err.Error() != "missing imported function: env.add F(IF), env.log v(i)" {
+		t.Fatalf("Unexpected message: %q", err)
+	}
+	if _, err := runtime.FindFunction("run"); err == nil {
+		t.Fatal("The module shouldn't be loaded")
+	}
+	// Nothing was linked into the rejected module, it's still a usable handle
+	if len(runtime.hostFunctions) != 0 || len(module.Imports()) != 3 || module.NumFunctions() != 5 {
+		t.Fatalf("Unexpected state: %d host functions, %d imports, %d functions",
+			len(runtime.hostFunctions), len(module.Imports()), module.NumFunctions())
+	}
+
+	// Once everything is linked the module loads
+	if err := runtime.RegisterHostFunc("env", "add", func(x int64, y float64) float64 { return y }); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.RegisterHostFunc("env", "log", func(x int32) {}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.LoadModule(module); err != nil {
+		t.Fatal(err)
+	}
+	fn, err := runtime.FindFunction("run_fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := fn.Call(); err != nil || result != int32(1) {
+		t.Fatalf("Unexpected result: %v, %v", result, err)
+	}
+
+	// The spec test functions are linked by WASM3
+	spec := &testModule{}
+	spec.addImport(testImport{module: "spectest", field: "print_i32", params: []byte{i32}})
+	if _, err := runtime.Load(spec.bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+package wasm3
+
+/*
+#include <stdlib.h>
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"errors"
+	"unsafe"
+)
+
+// ErrNoEntryPoint is returned by RunMain when the module exports neither _start nor _initialize
+var ErrNoEntryPoint = errors.New("Module doesn't export _start or _initialize")
+
+// RunMain runs a WASI command like exec.Command runs a program: it calls _start, or _initialize
+// for reactors, and returns the exit status. proc_exit sets the status without an error and
+// returning from _start is status 0, err is only set for traps and other failures (the status
+// is -1 then). args replace the guest arguments when given, args[0] is the program name.
+// ErrNoEntryPoint is only returned when neither function is exported, entry points that
+// fail to compile return their error.
+func(r *Runtime) RunMain(args ...string) (int, error) {
+	if len(args) > 0 {
+		if r.wasi != nil {
+			r.wasi.args = args
+		}
+		if r.cfg.EnableWASI {
+			r.setArgs(args)
+		}
+	}
+	fn, err := r.FindFunction("_start")
+	if errors.Is(err, ErrFunctionLookupFailed) {
+		fn, err = r.FindFunction("_initialize")
+		if errors.Is(err, ErrFunctionLookupFailed) {
+			return -1, ErrNoEntryPoint
+		}
+	}
+	// An entry point that fails to compile, e.g. with a missing import, returns its error
+	if err != nil {
+		return -1, err
+	}
+	_, err = fn.Call()
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return int(exitErr.Code), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// setArgs sets the arguments of the C WASI, they're freed by Destroy or the next call
+func(r *Runtime) setArgs(args []string) {
+	r.freeArgs()
+	argv := (*[1 << 28]*C.char)(C.malloc(C.size_t(len(args)) * C.size_t(unsafe.Sizeof(uintptr(0)))))
+	r.argv = argv[:len(args):len(args)]
+	for i, arg := range args {
+		r.argv[i] = C.CString(arg)
+	}
+	r.Ptr().argc = C.u32(len(args))
+	r.Ptr().argv = (*C.ccstr_t)(unsafe.Pointer(&r.argv[0]))
+}
+
+func(r *Runtime) freeArgs() {
+	if r.argv == nil {
+		return
+	}
+	r.Ptr().argc = 0
+	r.Ptr().argv = nil
+	for _, arg := range r.argv {
+		C.free(unsafe.Pointer(arg))
+	}
+	C.free(unsafe.Pointer(&r.argv[0]))
+	r.argv = nil
+}
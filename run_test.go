@@ -0,0 +1,75 @@
+package wasm3
+
+import (
+	"errors"
+	"testing"
+)
+
+// commandTestModule exports a _start that exits with the number of arguments
+func commandTestModule(wasiModule string) *testModule {
+	m := &testModule{memory: &testMemory{min: 1}}
+	argsSizesGet := m.addImport(testImport{module: wasiModule, field: "args_sizes_get", params: []byte{i32, i32}, results: []byte{i32}})
+	procExit := m.addImport(testImport{module: wasiModule, field: "proc_exit", params: []byte{i32}})
+	m.addFunc(testFunc{export: "_start", code: []byte{
+		0x41, 0, 0x41, 4, 0x10, byte(argsSizesGet), 0x1a,
+		0x41, 0, 0x28, 2, 0, 0x10, byte(procExit),
+	}})
+	return m
+}
+
+func runMain(t *testing.T, cfg *Config, m *testModule, args ...string) (int, error) {
+	t.Helper()
+	cfg.Environment = NewEnvironment()
+	cfg.StackSize = 64 * 1024
+	runtime := NewRuntime(cfg)
+	defer runtime.Destroy()
+	if _, err := runtime.Load(m.bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return runtime.RunMain(args...)
+}
+
+func TestRunMain(t *testing.T) {
+	code, err := runMain(t, &Config{WASI: &WASIConfig{}}, commandTestModule(wasiModuleName), "prog", "a", "b")
+	if err != nil || code != 3 {
+		t.Fatalf("Expected exit status 3, got %d, %v", code, err)
+	}
+	code, err = runMain(t, &Config{WASI: &WASIConfig{}}, commandTestModule(wasiModuleName))
+	if err != nil || code != 0 {
+		t.Fatalf("Expected exit status 0, got %d, %v", code, err)
+	}
+	// The C WASI gets the arguments too
+	code, err = runMain(t, &Config{EnableWASI: true}, commandTestModule("wasi_unstable"), "prog", "a")
+	if err != nil || code != 2 {
+		t.Fatalf("Expected exit status 2, got %d, %v", code, err)
+	}
+
+	trap := &testModule{}
+	trap.addFunc(testFunc{export: "_start", code: []byte{0x00}})
+	code, err = runMain(t, &Config{}, trap)
+	if !errors.Is(err, ErrTrapUnreachable) || code != -1 {
+		t.Fatalf("Expected the trap, got %d, %v", code, err)
+	}
+
+	reactor := &testModule{}
+	reactor.addFunc(testFunc{export: "_initialize"})
+	if code, err := runMain(t, &Config{}, reactor); err != nil || code != 0 {
+		t.Fatalf("Expected exit status 0, got %d, %v", code, err)
+	}
+
+	// The entry point exists but calls an import that isn't linked
+	unlinked := &testModule{}
+	missing := unlinked.addImport(testImport{module: "env", field: "missing"})
+	unlinked.addFunc(testFunc{export: "_start", code: []byte{0x10, byte(missing)}})
+	_, err = runMain(t, &Config{}, unlinked)
+	var linkErr *LinkError
+	if !errors.As(err, &linkErr) || !errors.Is(err, ErrFunctionImportMissing) {
+		t.Fatalf("Expected the missing import, got %v", err)
+	}
+
+	library := &testModule{}
+	library.addFunc(testFunc{export: "run"})
+	if _, err := runMain(t, &Config{}, library); err != ErrNoEntryPoint {
+		t.Fatalf("Expected ErrNoEntryPoint, got %v", err)
+	}
+}
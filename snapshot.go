@@ -0,0 +1,112 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"errors"
+	"unsafe"
+)
+
+var(
+	// ErrSnapshotMismatch is returned when a snapshot is restored into a different runtime or module set
+	ErrSnapshotMismatch = errors.New("Snapshot doesn't match the runtime")
+)
+
+// Snapshot holds the state of a runtime: the memory, the mutable globals and the tables of its modules.
+// It's taken and restored between calls, e.g. after initializing the guest so every request starts
+// from the same state without loading the module again.
+type Snapshot struct {
+	runtime *Runtime
+	memory []byte
+	pages uint32
+	modules []moduleSnapshot
+	// allocations holds the sizes tracked by the runtime allocator
+	allocations map[GuestPtr]uint32
+}
+
+// moduleSnapshot holds the state of a loaded module
+type moduleSnapshot struct {
+	module *Module
+	globals []globalSnapshot
+	table []C.IM3Function
+}
+
+type globalSnapshot struct {
+	index uint32
+	bits uint64
+}
+
+// Snapshot captures the runtime state, it must not be called from host functions
+func(r *Runtime) Snapshot() *Snapshot {
+	s := &Snapshot{
+		runtime: r,
+		memory: append([]byte(nil), r.memoryBytes()...),
+		pages: r.MemoryPages(),
+		modules: make([]moduleSnapshot, len(r.modules)),
+	}
+	for i, m := range r.modules {
+		module := m.Ptr()
+		ms := moduleSnapshot{module: m}
+		for j := uint32(0); j < uint32(module.numGlobals); j++ {
+			g := moduleGlobal(module, j)
+			if bool(g.isMutable) {
+				ms.globals = append(ms.globals, globalSnapshot{j, uint64(C.global_get_bits(g))})
+			}
+		}
+		if module.table0 != nil {
+			ms.table = append([]C.IM3Function(nil), moduleTable(module)...)
+		}
+		s.modules[i] = ms
+	}
+	if r.allocator != nil {
+		s.allocations = make(map[GuestPtr]uint32, len(r.allocator.sizes))
+		for ptr, size := range r.allocator.sizes {
+			s.allocations[ptr] = size
+		}
+	}
+	return s
+}
+
+// Restore rolls the runtime back to the snapshot, the memory shrinks or grows to the snapshot size.
+// The runtime must have the same modules it had when the snapshot was taken.
+func(r *Runtime) Restore(s *Snapshot) error {
+	if s.runtime != r || len(s.modules) != len(r.modules) {
+		return ErrSnapshotMismatch
+	}
+	for i, ms := range s.modules {
+		if ms.module != r.modules[i] {
+			return ErrSnapshotMismatch
+		}
+	}
+	if r.MemoryPages() != s.pages {
+		if result := C.ResizeMemory(r.Ptr(), C.u32(s.pages)); result != nil {
+			return newError(result, kindAny)
+		}
+	}
+	copy(r.memoryBytes(), s.memory)
+	for _, ms := range s.modules {
+		module := ms.module.Ptr()
+		for _, g := range ms.globals {
+			C.global_set_bits(moduleGlobal(module, g.index), C.uint64_t(g.bits))
+		}
+		if ms.table != nil {
+			copy(moduleTable(module), ms.table)
+		}
+	}
+	if r.allocator != nil {
+		r.allocator.sizes = make(map[GuestPtr]uint32, len(s.allocations))
+		for ptr, size := range s.allocations {
+			r.allocator.sizes[ptr] = size
+		}
+	}
+	return nil
+}
+
+// moduleTable returns the module table, it aliases the WASM3 memory
+func moduleTable(module C.IM3Module) []C.IM3Function {
+	n := int(module.table0Size)
+	return (*[1 << 28]C.IM3Function)(unsafe.Pointer(module.table0))[:n:n]
+}
@@ -0,0 +1,205 @@
+package wasm3
+
+import(
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Snapshot files start with snapshotMagic and snapshotVersion, followed by the state
+// and a CRC-32 (IEEE) of everything before it. Values are little-endian.
+//
+//	modules:     count, then the module hash and its mutable globals (index, bits)
+//	memory:      pages, then pages * PageSize bytes
+//	allocations: count, then (ptr, size) pairs sorted by ptr
+//
+// Tables hold pointers to compiled functions so they aren't persisted, they don't change after
+// the module is loaded.
+const(
+	snapshotMagic = "W3SN"
+	snapshotVersion = 1
+)
+
+var(
+	// ErrInvalidSnapshot is returned when a persisted snapshot is truncated, corrupted or has an unknown version
+	ErrInvalidSnapshot = errors.New("Invalid snapshot")
+)
+
+// snapshotWriter writes little-endian values and keeps the first error
+type snapshotWriter struct {
+	w io.Writer
+	n int64
+	err error
+}
+
+func(sw *snapshotWriter) write(b []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(b)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func(sw *snapshotWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	sw.write(b[:])
+}
+
+func(sw *snapshotWriter) uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	sw.write(b[:])
+}
+
+// WriteTo persists the snapshot: the memory, the mutable globals, the allocations tracked by the
+// runtime allocator and the hashes of the modules. It can be read by a runtime with the same
+// modules with ReadSnapshot.
+func(s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	checksum := crc32.NewIEEE()
+	sw := &snapshotWriter{w: io.MultiWriter(bw, checksum)}
+	sw.write([]byte(snapshotMagic))
+	sw.uint32(snapshotVersion)
+	sw.uint32(uint32(len(s.modules)))
+	for _, ms := range s.modules {
+		sw.write(ms.module.hash[:])
+		sw.uint32(uint32(len(ms.globals)))
+		for _, g := range ms.globals {
+			sw.uint32(g.index)
+			sw.uint64(g.bits)
+		}
+	}
+	sw.uint32(s.pages)
+	sw.write(s.memory)
+	// The allocations are sorted so the same snapshot is always written the same way
+	ptrs := make([]GuestPtr, 0, len(s.allocations))
+	for ptr := range s.allocations {
+		ptrs = append(ptrs, ptr)
+	}
+	sort.Slice(ptrs, func(i, j int) bool { return ptrs[i] < ptrs[j] })
+	sw.uint32(uint32(len(ptrs)))
+	for _, ptr := range ptrs {
+		sw.uint32(ptr)
+		sw.uint32(s.allocations[ptr])
+	}
+	sw.w = bw
+	sw.uint32(checksum.Sum32())
+	if sw.err == nil {
+		sw.err = bw.Flush()
+	}
+	return sw.n, sw.err
+}
+
+// snapshotReader reads little-endian values and keeps the first error
+type snapshotReader struct {
+	r io.Reader
+	err error
+}
+
+func(sr *snapshotReader) read(b []byte) {
+	if sr.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		sr.err = fmt.Errorf("%w: %s", ErrInvalidSnapshot, err)
+	}
+}
+
+func(sr *snapshotReader) uint32() uint32 {
+	var b [4]byte
+	sr.read(b[:])
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+func(sr *snapshotReader) uint64() uint64 {
+	var b [8]byte
+	sr.read(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// fail keeps the first error
+func(sr *snapshotReader) fail(err error) {
+	if sr.err == nil {
+		sr.err = err
+	}
+}
+
+// ReadSnapshot reads a snapshot written with Snapshot.WriteTo, it can be restored with Restore.
+// The runtime must have loaded the same modules (compared by the hash of their WASM binaries)
+// in the same order, ErrSnapshotMismatch is returned otherwise.
+func(r *Runtime) ReadSnapshot(rd io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(rd)
+	checksum := crc32.NewIEEE()
+	sr := &snapshotReader{r: io.TeeReader(br, checksum)}
+	magic := make([]byte, len(snapshotMagic))
+	sr.read(magic)
+	if sr.err == nil && string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrInvalidSnapshot, magic)
+	}
+	if version := sr.uint32(); sr.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+	s := &Snapshot{runtime: r}
+	if n := sr.uint32(); sr.err == nil && int(n) != len(r.modules) {
+		return nil, fmt.Errorf("%w: %d modules, the runtime has %d", ErrSnapshotMismatch, n, len(r.modules))
+	}
+	for i := 0; sr.err == nil && i < len(r.modules); i++ {
+		m := r.modules[i]
+		ms := moduleSnapshot{module: m}
+		var hash [len(m.hash)]byte
+		sr.read(hash[:])
+		if sr.err == nil && hash != m.hash {
+			sr.fail(fmt.Errorf("%w: module %d has a different hash", ErrSnapshotMismatch, i))
+		}
+		n := sr.uint32()
+		for j := uint32(0); sr.err == nil && j < n; j++ {
+			g := globalSnapshot{index: sr.uint32(), bits: sr.uint64()}
+			if sr.err == nil && (g.index >= uint32(m.Ptr().numGlobals) || !bool(moduleGlobal(m.Ptr(), g.index).isMutable)) {
+				sr.fail(fmt.Errorf("%w: global %d of module %d isn't mutable", ErrInvalidSnapshot, g.index, i))
+			}
+			ms.globals = append(ms.globals, g)
+		}
+		s.modules = append(s.modules, ms)
+	}
+	// The header isn't trusted until the checksum is verified, the sizes are bounded by
+	// the memory limit and the memory is only allocated as it's read
+	s.pages = sr.uint32()
+	if sr.err == nil && (s.pages > uint32(r.Ptr().memory.maxPages) || (r.cfg.MaxMemoryPages > 0 && s.pages > r.cfg.MaxMemoryPages)) {
+		sr.fail(fmt.Errorf("%w: %d memory pages, the limit is %d", ErrInvalidSnapshot, s.pages, r.Ptr().memory.maxPages))
+	}
+	if sr.err == nil {
+		var memory bytes.Buffer
+		size := int64(s.pages) * PageSize
+		if n, err := io.CopyN(&memory, sr.r, size); err != nil {
+			sr.fail(fmt.Errorf("%w: read %d of %d memory bytes: %s", ErrInvalidSnapshot, n, size, err))
+		}
+		s.memory = memory.Bytes()
+	}
+	n := sr.uint32()
+	if sr.err == nil && uint64(n) > uint64(s.pages) * PageSize {
+		sr.fail(fmt.Errorf("%w: %d allocations", ErrInvalidSnapshot, n))
+	}
+	if sr.err == nil && n > 0 {
+		s.allocations = make(map[GuestPtr]uint32)
+		for i := uint32(0); sr.err == nil && i < n; i++ {
+			ptr := sr.uint32()
+			s.allocations[ptr] = sr.uint32()
+		}
+	}
+	sum := checksum.Sum32()
+	sr.r = br
+	if expected := sr.uint32(); sr.err == nil && expected != sum {
+		sr.fail(fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot))
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	return s, nil
+}
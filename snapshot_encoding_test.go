@@ -0,0 +1,125 @@
+package wasm3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestSnapshotEncoding(t *testing.T) {
+	runtime := loadTestModule(t, allocTestModule())
+	defer runtime.Destroy()
+	if err := runtime.UseAllocator("alloc", "free"); err != nil {
+		t.Fatal(err)
+	}
+	ptr, err := runtime.AllocString("warm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.GrowMemory(1); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := runtime.Snapshot().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+	data := buf.Bytes()
+
+	resumed := loadTestModule(t, allocTestModule())
+	defer resumed.Destroy()
+	if err := resumed.UseAllocator("alloc", "free"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := resumed.ReadSnapshot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if pages := resumed.MemoryPages(); pages != 3 {
+		t.Fatalf("Unexpected number of pages: %d", pages)
+	}
+	if s, err := resumed.Memory().ReadCString(ptr); err != nil || s != "warm" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	// The heap pointer global and the allocation sizes are restored
+	if next, err := resumed.Alloc(1); err != nil || next != ptr+5 {
+		t.Fatalf("Unexpected allocation: %d, %v", next, err)
+	}
+	if err := resumed.Free(ptr); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	if _, err := resumed.ReadSnapshot(bytes.NewReader(corrupted)); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+	if _, err := resumed.ReadSnapshot(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+	version := append([]byte(nil), data...)
+	version[4] = 99
+	if _, err := resumed.ReadSnapshot(bytes.NewReader(version)); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+	}
+
+	// Sizes from a corrupted header are rejected before they're allocated, the snapshot
+	// holds one allocation and 3 memory pages
+	countOffset := len(data) - 4 - 8 - 4
+	pagesOffset := countOffset - 3*PageSize - 4
+	for _, tc := range []struct {
+		offset int
+		value  uint32
+	}{
+		{pagesOffset, 70000},
+		{pagesOffset, 60000},
+		{countOffset, 1 << 31},
+	} {
+		tampered := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(tampered[tc.offset:], tc.value)
+		if _, err := resumed.ReadSnapshot(bytes.NewReader(tampered)); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("Expected ErrInvalidSnapshot for %d at %d, got %v", tc.value, tc.offset, err)
+		}
+	}
+
+	different := loadTestModule(t, memoryTestModule())
+	defer different.Destroy()
+	if _, err := different.ReadSnapshot(bytes.NewReader(data)); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("Expected ErrSnapshotMismatch, got %v", err)
+	}
+}
+
+func TestSnapshotEncodingDeterministic(t *testing.T) {
+	runtime := loadTestModule(t, allocTestModule())
+	defer runtime.Destroy()
+	if err := runtime.UseAllocator("alloc", "free"); err != nil {
+		t.Fatal(err)
+	}
+	// Enough allocations for the map order to differ between two iterations
+	for i := 0; i < 64; i++ {
+		if _, err := runtime.Alloc(uint32(i + 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := runtime.Snapshot()
+	var first, second bytes.Buffer
+	if _, err := snapshot.WriteTo(&first); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		second.Reset()
+		if _, err := snapshot.WriteTo(&second); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.Bytes(), second.Bytes()) {
+			t.Fatal("The same snapshot was written differently")
+		}
+	}
+}
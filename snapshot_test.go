@@ -0,0 +1,67 @@
+package wasm3
+
+import (
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	runtime := loadTestModule(t, allocTestModule())
+	defer runtime.Destroy()
+	if err := runtime.UseAllocator("alloc", "free"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := runtime.AllocString("init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := runtime.Snapshot()
+
+	second, err := runtime.AllocBytes([]byte("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.GrowMemory(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.Memory().WriteString(first, "INIT"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runtime.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if pages := runtime.MemoryPages(); pages != 2 {
+		t.Fatalf("Unexpected number of pages: %d", pages)
+	}
+	if s, err := runtime.Memory().ReadCString(first); err != nil || s != "init" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if b, err := runtime.Memory().ReadUint8(second); err != nil || b != 0 {
+		t.Fatalf("The memory wasn't restored: %v, %v", b, err)
+	}
+	// The allocator global was restored too
+	if ptr, err := runtime.AllocBytes([]byte("again")); err != nil || ptr != second {
+		t.Fatalf("Expected the allocation at %d, got %d (%v)", second, ptr, err)
+	}
+	if err := runtime.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.Free(second); err == nil {
+		t.Fatal("The allocations made after the snapshot should be forgotten")
+	}
+	if err := runtime.Free(first); err != nil {
+		t.Fatal(err)
+	}
+
+	other := loadTestModule(t, allocTestModule())
+	defer other.Destroy()
+	if err := other.Restore(snapshot); err != ErrSnapshotMismatch {
+		t.Fatalf("Expected ErrSnapshotMismatch, got %v", err)
+	}
+	if _, err := runtime.Load(memoryTestModule().bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.Restore(snapshot); err != ErrSnapshotMismatch {
+		t.Fatalf("Expected ErrSnapshotMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package wasm3
+
+import(
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// DefaultMaxStringLength is the default limit of the string helpers (1MiB)
+const DefaultMaxStringLength = 1 << 20
+
+var(
+	// ErrStringTooLong is returned when a string exceeds Memory.MaxStringLength
+	ErrStringTooLong = errors.New("String exceeds the maximum length")
+	// ErrStringContainsNUL is returned when writing a NUL-terminated string that contains NUL characters
+	ErrStringContainsNUL = errors.New("String contains a NUL character")
+)
+
+func(m *Memory) maxStringLength() uint32 {
+	if m.MaxStringLength == 0 {
+		return DefaultMaxStringLength
+	}
+	return m.MaxStringLength
+}
+
+// checkLength returns ErrStringTooLong when length exceeds the maximum length
+func(m *Memory) checkLength(length uint64) error {
+	if length > uint64(m.maxStringLength()) {
+		return fmt.Errorf("%w: %d bytes, the maximum is %d", ErrStringTooLong, length, m.maxStringLength())
+	}
+	return nil
+}
+
+// terminated returns the bytes starting at ptr up to the first terminator of the given size (1 or 2 bytes),
+// the terminator isn't included.
+func(m *Memory) terminated(ptr, size uint32) ([]byte, error) {
+	data := m.runtime.memoryBytes()
+	if uint64(ptr) >= uint64(len(data)) {
+		return nil, fmt.Errorf("%w: string at %d, memory size is %d", ErrMemoryOutOfRange, ptr, len(data))
+	}
+	data = data[ptr:]
+	// The terminator is only searched within the maximum length
+	limit := uint64(m.maxStringLength()) + uint64(size)
+	truncated := uint64(len(data)) > limit
+	if truncated {
+		data = data[:limit]
+	}
+	if size == 1 {
+		if i := bytes.IndexByte(data, 0); i != -1 {
+			return data[:i], nil
+		}
+	} else {
+		for i := 0; i + 1 < len(data); i += 2 {
+			if data[i] == 0 && data[i + 1] == 0 {
+				return data[:i], nil
+			}
+		}
+	}
+	if truncated {
+		return nil, fmt.Errorf("%w: string at %d isn't terminated within %d bytes", ErrStringTooLong, ptr, m.maxStringLength())
+	}
+	return nil, fmt.Errorf("%w: string at %d isn't terminated", ErrMemoryOutOfRange, ptr)
+}
+
+// ReadCString reads a NUL-terminated string
+func(m *Memory) ReadCString(ptr uint32) (string, error) {
+	b, err := m.terminated(ptr, 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ReadString reads a string of length bytes
+func(m *Memory) ReadString(ptr, length uint32) (string, error) {
+	if err := m.checkLength(uint64(length)); err != nil {
+		return "", err
+	}
+	b, err := m.slice(ptr, length)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// WriteCString writes s followed by a NUL character, it uses len(s) + 1 bytes
+func(m *Memory) WriteCString(ptr uint32, s string) error {
+	if strings.IndexByte(s, 0) != -1 {
+		return ErrStringContainsNUL
+	}
+	if err := m.checkLength(uint64(len(s))); err != nil {
+		return err
+	}
+	b, err := m.slice(ptr, uint32(len(s) + 1))
+	if err != nil {
+		return err
+	}
+	b[copy(b, s)] = 0
+	return nil
+}
+
+// WriteString writes s without a terminator, it uses len(s) bytes
+func(m *Memory) WriteString(ptr uint32, s string) error {
+	if err := m.checkLength(uint64(len(s))); err != nil {
+		return err
+	}
+	b, err := m.slice(ptr, uint32(len(s)))
+	if err != nil {
+		return err
+	}
+	copy(b, s)
+	return nil
+}
+
+// decodeUTF16 decodes little-endian UTF-16, invalid surrogates are replaced with U+FFFD
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, len(b) / 2)
+	for i := range units {
+		units[i] = uint16(b[2 * i]) | uint16(b[2 * i + 1]) << 8
+	}
+	return string(utf16.Decode(units))
+}
+
+// encodeUTF16 encodes s as little-endian UTF-16
+func encodeUTF16(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2 * len(units))
+	for i, u := range units {
+		b[2 * i] = byte(u)
+		b[2 * i + 1] = byte(u >> 8)
+	}
+	return b
+}
+
+// ReadUTF16CString reads a little-endian UTF-16 string terminated by a NUL code unit
+func(m *Memory) ReadUTF16CString(ptr uint32) (string, error) {
+	b, err := m.terminated(ptr, 2)
+	if err != nil {
+		return "", err
+	}
+	return decodeUTF16(b), nil
+}
+
+// ReadUTF16String reads a little-endian UTF-16 string of length code units
+func(m *Memory) ReadUTF16String(ptr, length uint32) (string, error) {
+	if err := m.checkLength(2 * uint64(length)); err != nil {
+		return "", err
+	}
+	b, err := m.slice(ptr, 2 * length)
+	if err != nil {
+		return "", err
+	}
+	return decodeUTF16(b), nil
+}
+
+// WriteUTF16CString writes s as little-endian UTF-16 followed by a NUL code unit
+func(m *Memory) WriteUTF16CString(ptr uint32, s string) error {
+	if strings.IndexByte(s, 0) != -1 {
+		return ErrStringContainsNUL
+	}
+	encoded := encodeUTF16(s)
+	if err := m.checkLength(uint64(len(encoded))); err != nil {
+		return err
+	}
+	return m.Write(ptr, append(encoded, 0, 0))
+}
+
+// WriteUTF16String writes s as little-endian UTF-16 without a terminator,
+// it returns the number of code units written.
+func(m *Memory) WriteUTF16String(ptr uint32, s string) (uint32, error) {
+	encoded := encodeUTF16(s)
+	if err := m.checkLength(uint64(len(encoded))); err != nil {
+		return 0, err
+	}
+	if err := m.Write(ptr, encoded); err != nil {
+		return 0, err
+	}
+	return uint32(len(encoded) / 2), nil
+}
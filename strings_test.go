@@ -0,0 +1,126 @@
+package wasm3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStrings(t *testing.T) {
+	runtime := loadTestModule(t, memoryTestModule())
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	if err := mem.WriteCString(100, "héllo"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := mem.ReadCString(100); err != nil || s != "héllo" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if b, _ := mem.ReadUint8(100 + uint32(len("héllo"))); b != 0 {
+		t.Fatal("The string must be NUL-terminated")
+	}
+	if s, err := mem.ReadString(100, 3); err != nil || s != "hé" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if err := mem.WriteString(200, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := mem.ReadString(200, 3); err != nil || s != "abc" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if s, err := mem.ReadCString(300); err != nil || s != "" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if err := mem.WriteCString(0, "a\x00b"); err != ErrStringContainsNUL {
+		t.Fatalf("Expected a NUL error, got %v", err)
+	}
+
+	if err := mem.WriteUTF16CString(400, "h€llo 😀"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := mem.ReadUTF16CString(400); err != nil || s != "h€llo 😀" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	n, err := mem.WriteUTF16String(500, "😀ab")
+	if err != nil || n != 4 {
+		t.Fatalf("Unexpected length: %d, %v", n, err)
+	}
+	if b, _ := mem.Read(500, 8); string(b[4:]) != "a\x00b\x00" {
+		t.Fatalf("Unexpected encoding: %x", b)
+	}
+	if s, err := mem.ReadUTF16String(500, n); err != nil || s != "😀ab" {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if err := mem.WriteUTF16CString(0, "\x00"); err != ErrStringContainsNUL {
+		t.Fatalf("Expected a NUL error, got %v", err)
+	}
+}
+
+func TestMemoryStringGuards(t *testing.T) {
+	runtime := loadTestModule(t, memoryTestModule())
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+	mem.MaxStringLength = 8
+
+	long := strings.Repeat("x", 9)
+	if err := mem.WriteCString(0, long); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+	if err := mem.WriteString(0, long); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+	if _, err := mem.WriteUTF16String(0, "12345"); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+	if err := mem.WriteUTF16CString(0, "12345"); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+	if _, err := mem.ReadString(0, 9); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+	if _, err := mem.ReadUTF16String(0, 5); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+
+	// Strings of the maximum length are accepted
+	if err := mem.WriteCString(0, long[:8]); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := mem.ReadCString(0); err != nil || s != long[:8] {
+		t.Fatalf("Unexpected string: %q, %v", s, err)
+	}
+	if err := mem.Write(0, []byte(long+"\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.ReadCString(0); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+	if err := mem.Write(0, []byte("123456789\x00\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.ReadUTF16CString(0); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("Expected a length error, got %v", err)
+	}
+
+	// Strings that run past the end of the memory
+	mem.MaxStringLength = 0
+	if err := mem.Write(65532, []byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.ReadCString(65532); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+	if _, err := mem.ReadCString(65536); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+	if _, err := mem.ReadUTF16CString(65533); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+	if err := mem.WriteCString(65532, "abcd"); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+	if _, err := mem.ReadString(65535, 2); !errors.Is(err, ErrMemoryOutOfRange) {
+		t.Fatalf("Expected an out of range error, got %v", err)
+	}
+}
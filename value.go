@@ -0,0 +1,301 @@
+package wasm3
+
+/*
+#include "go-wasm3.h"
+*/
+import "C"
+
+import(
+	"fmt"
+	"math"
+)
+
+// ValueType mirrors the M3 value types (c_m3Type_*)
+type ValueType uint8
+
+const(
+	// TypeNone is used as the result type of functions that don't return a value
+	TypeNone ValueType = C.c_m3Type_none
+	// TypeI32 is a 32 bit integer
+	TypeI32 ValueType = C.c_m3Type_i32
+	// TypeI64 is a 64 bit integer
+	TypeI64 ValueType = C.c_m3Type_i64
+	// TypeF32 is a 32 bit float
+	TypeF32 ValueType = C.c_m3Type_f32
+	// TypeF64 is a 64 bit float
+	TypeF64 ValueType = C.c_m3Type_f64
+)
+
+// String returns the WASM name of the type
+func(t ValueType) String() string {
+	switch t {
+	case TypeNone:
+		return "none"
+	case TypeI32:
+		return "i32"
+	case TypeI64:
+		return "i64"
+	case TypeF32:
+		return "f32"
+	case TypeF64:
+		return "f64"
+	}
+	return "unknown"
+}
+
+// compact returns the character used for the type in WASM3 signature strings
+func(t ValueType) compact() byte {
+	switch t {
+	case TypeI32:
+		return 'i'
+	case TypeI64:
+		return 'I'
+	case TypeF32:
+		return 'f'
+	case TypeF64:
+		return 'F'
+	}
+	return 'v'
+}
+
+// Value is a WASM value with its type, see I32, I64, F32 and F64.
+// Values are accepted wherever Go values are converted to WASM, e.g. function arguments,
+// and their type must match exactly.
+type Value interface {
+	Type() ValueType
+	bits() uint64
+}
+
+// I32 is an i32 value
+type I32 int32
+
+// I64 is an i64 value
+type I64 int64
+
+// F32 is an f32 value
+type F32 float32
+
+// F64 is an f64 value
+type F64 float64
+
+// Type returns TypeI32
+func(v I32) Type() ValueType { return TypeI32 }
+
+// Type returns TypeI64
+func(v I64) Type() ValueType { return TypeI64 }
+
+// Type returns TypeF32
+func(v F32) Type() ValueType { return TypeF32 }
+
+// Type returns TypeF64
+func(v F64) Type() ValueType { return TypeF64 }
+
+func(v I32) bits() uint64 { return uint64(uint32(v)) }
+func(v I64) bits() uint64 { return uint64(v) }
+func(v F32) bits() uint64 { return uint64(math.Float32bits(float32(v))) }
+func(v F64) bits() uint64 { return math.Float64bits(float64(v)) }
+
+// Signature describes the parameters and result of a function
+type Signature struct {
+	Params []ValueType
+	Result ValueType
+}
+
+// NumParams returns the function arity
+func(s *Signature) NumParams() int {
+	return len(s.Params)
+}
+
+// NumResults returns the number of values returned by the function (0 or 1)
+func(s *Signature) NumResults() int {
+	if s.Result == TypeNone {
+		return 0
+	}
+	return 1
+}
+
+// String returns the signature using the WASM3 notation, e.g. "I(iF)"
+func(s *Signature) String() string {
+	buf := make([]byte, 0, len(s.Params) + 3)
+	buf = append(buf, s.Result.compact(), '(')
+	for _, p := range s.Params {
+		buf = append(buf, p.compact())
+	}
+	return string(append(buf, ')'))
+}
+
+// ParseSignature parses a signature using the WASM3 notation, e.g. "i(iI)".
+// "*" is accepted as an alias for i32 and "v" for no result.
+func ParseSignature(str string) (*Signature, error) {
+	s := &Signature{}
+	parsedResult, inParams, closed := false, false, false
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if c == ' ' {
+			continue
+		}
+		if closed {
+			return nil, fmt.Errorf("invalid signature %q", str)
+		}
+		switch c {
+		case '(':
+			if !parsedResult || inParams {
+				return nil, fmt.Errorf("invalid signature %q", str)
+			}
+			inParams = true
+			continue
+		case ')':
+			if !inParams {
+				return nil, fmt.Errorf("invalid signature %q", str)
+			}
+			closed = true
+			continue
+		}
+		var t ValueType
+		switch c {
+		case 'i', '*':
+			t = TypeI32
+		case 'I':
+			t = TypeI64
+		case 'f':
+			t = TypeF32
+		case 'F':
+			t = TypeF64
+		case 'v':
+			if inParams {
+				return nil, fmt.Errorf("invalid parameter type 'v' in signature %q", str)
+			}
+			t = TypeNone
+		default:
+			return nil, fmt.Errorf("invalid type %q in signature %q", c, str)
+		}
+		if inParams {
+			s.Params = append(s.Params, t)
+		} else if !parsedResult {
+			s.Result = t
+			parsedResult = true
+		} else {
+			return nil, fmt.Errorf("invalid signature %q", str)
+		}
+	}
+	if !closed {
+		return nil, fmt.Errorf("invalid signature %q", str)
+	}
+	return s, nil
+}
+
+// Equal reports whether both signatures have the same parameter and result types
+func(s *Signature) Equal(other *Signature) bool {
+	if s.Result != other.Result || len(s.Params) != len(other.Params) {
+		return false
+	}
+	for i := range s.Params {
+		if s.Params[i] != other.Params[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newSignature builds a signature from a IM3FuncType
+func newSignature(ftype C.IM3FuncType) *Signature {
+	s := &Signature{
+		Params: make([]ValueType, int(ftype.numArgs)),
+		Result: ValueType(ftype.returnType),
+	}
+	for i := range s.Params {
+		s.Params[i] = ValueType(ftype.argTypes[i])
+	}
+	return s
+}
+
+// toSlot converts a Go value into a stack slot of the given M3 type.
+func toSlot(v interface{}, t ValueType) (uint64, error) {
+	if val, ok := v.(Value); ok {
+		if val.Type() != t {
+			return 0, fmt.Errorf("cannot use %v (%s) as %s", v, val.Type(), t)
+		}
+		return val.bits(), nil
+	}
+	switch t {
+	case TypeI32:
+		n, ok := toInt64(v)
+		if !ok || n < math.MinInt32 || n > math.MaxUint32 {
+			break
+		}
+		return uint64(uint32(n)), nil
+	case TypeI64:
+		if u, ok := v.(uint64); ok {
+			return u, nil
+		}
+		if u, ok := v.(uint); ok {
+			return uint64(u), nil
+		}
+		n, ok := toInt64(v)
+		if !ok {
+			break
+		}
+		return uint64(n), nil
+	case TypeF32:
+		switch f := v.(type) {
+		case float32:
+			return uint64(math.Float32bits(f)), nil
+		case float64:
+			return uint64(math.Float32bits(float32(f))), nil
+		}
+	case TypeF64:
+		switch f := v.(type) {
+		case float32:
+			return math.Float64bits(float64(f)), nil
+		case float64:
+			return math.Float64bits(f), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot use %v (%T) as %s", v, v, t)
+}
+
+// fromSlot converts a stack slot of the given M3 type into a Go value.
+func fromSlot(slot uint64, t ValueType) interface{} {
+	switch t {
+	case TypeI32:
+		return int32(slot)
+	case TypeI64:
+		return int64(slot)
+	case TypeF32:
+		return math.Float32frombits(uint32(slot))
+	case TypeF64:
+		return math.Float64frombits(slot)
+	}
+	return nil
+}
+
+// toInt64 converts any Go integer to an int64, uint64 values above MaxInt64 aren't accepted.
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint:
+		if uint64(n) <= math.MaxInt64 {
+			return int64(n), true
+		}
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
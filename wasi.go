@@ -0,0 +1,347 @@
+package wasm3
+
+import(
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// wasiModuleName is the import module of the WASI functions implemented in Go
+const wasiModuleName = "wasi_snapshot_preview1"
+
+// WASIConfig configures the Go implementation of WASI, both wasi_snapshot_preview1 and
+// wasi_unstable. Guests only see the arguments, environment and directories set here.
+type WASIConfig struct {
+	// Args are the command line arguments, starting with the program name
+	Args []string
+	// Env holds the environment variables as "KEY=value" strings
+	Env []string
+	// Preopens maps guest paths to the host directories the guest can access, e.g. {"/data": "/srv/data"}.
+	// Paths can't escape these directories, they're mounted with DirFS.
+	Preopens map[string]string
+	// Mounts maps guest paths to filesystems, e.g. an embed.FS or a fstest.MapFS. They're read-only
+	// unless they implement WritableFS, and take precedence over Preopens with the same guest path.
+	Mounts map[string]fs.FS
+	// ReadOnly denies creating, writing, renaming and removing files in every mount
+	ReadOnly bool
+	// Stdin is read by the guest, it reads nothing when it's nil
+	Stdin io.Reader
+	// Stdout and Stderr receive the guest output, it's discarded when they're nil.
+	// They're looked up on every write so they can be swapped between calls.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Clock is used by clock_time_get, clock_res_get and the poll_oneoff timeouts, it's the system
+	// clock when it's nil. A FakeClock makes the runs reproducible.
+	Clock Clock
+	// Rand fills the random_get buffers, it's crypto/rand when it's nil.
+	// A seeded math/rand.Rand makes the guest randomness reproducible.
+	Rand io.Reader
+}
+
+// ExitError is returned by calls that end with the WASI proc_exit
+type ExitError struct {
+	Code uint32
+}
+
+func(e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Is makes errors.Is(err, ErrTrapExit) work
+func(e *ExitError) Is(target error) bool {
+	return target == ErrTrapExit
+}
+
+// wasiErrno is a WASI error code
+type wasiErrno uint32
+
+const(
+	errnoSuccess wasiErrno = 0
+	errno2Big wasiErrno = 1
+	errnoAcces wasiErrno = 2
+	errnoBadf wasiErrno = 8
+	errnoExist wasiErrno = 20
+	errnoFault wasiErrno = 21
+	errnoInval wasiErrno = 28
+	errnoIO wasiErrno = 29
+	errnoIsdir wasiErrno = 31
+	errnoLoop wasiErrno = 32
+	errnoNametoolong wasiErrno = 37
+	errnoNoent wasiErrno = 44
+	errnoNosys wasiErrno = 52
+	errnoNotdir wasiErrno = 54
+	errnoNotempty wasiErrno = 55
+	errnoNotsup wasiErrno = 58
+	errnoPerm wasiErrno = 63
+	errnoRofs wasiErrno = 69
+	errnoSpipe wasiErrno = 70
+	errnoXdev wasiErrno = 75
+	errnoNotcapable wasiErrno = 76
+)
+
+// wasiState holds the WASI state of a runtime
+type wasiState struct {
+	cfg *WASIConfig
+	// args are the configured Args unless RunMain replaced them
+	args []string
+	files map[uint32]*wasiFile
+	clock Clock
+}
+
+// newWASI creates the WASI state, fds 0-2 are the standard streams and the mounts
+// follow sorted by guest path.
+func newWASI(cfg *WASIConfig) *wasiState {
+	w := &wasiState{
+		cfg: cfg,
+		args: cfg.Args,
+		files: map[uint32]*wasiFile{
+			0: {stdio: true, stream: 0, readable: true},
+			1: {stdio: true, stream: 1, writable: true},
+			2: {stdio: true, stream: 2, writable: true},
+		},
+		clock: cfg.Clock,
+	}
+	if w.clock == nil {
+		w.clock = &systemClock{start: time.Now()}
+	}
+	mounts := make(map[string]fs.FS, len(cfg.Preopens) + len(cfg.Mounts))
+	for guestPath, hostPath := range cfg.Preopens {
+		mounts[guestPath] = DirFS(hostPath)
+	}
+	for guestPath, fsys := range cfg.Mounts {
+		mounts[guestPath] = fsys
+	}
+	guestPaths := make([]string, 0, len(mounts))
+	for guestPath := range mounts {
+		guestPaths = append(guestPaths, guestPath)
+	}
+	sort.Strings(guestPaths)
+	for i, guestPath := range guestPaths {
+		w.files[uint32(3 + i)] = &wasiFile{
+			preopen: true,
+			mount: &wasiMount{guestPath: guestPath, fsys: mounts[guestPath]},
+			name: ".",
+			dir: true,
+		}
+	}
+	return w
+}
+
+// reader returns the reader of a file
+func(w *wasiState) reader(f *wasiFile) io.Reader {
+	if !f.stdio {
+		return f.file
+	}
+	if f.stream == 0 && w.cfg.Stdin != nil {
+		return w.cfg.Stdin
+	}
+	return bytes.NewReader(nil)
+}
+
+// writer returns the writer of a file
+func(w *wasiState) writer(f *wasiFile) io.Writer {
+	if !f.stdio {
+		// path_open checks that the files opened for writing are writers
+		return f.file.(io.Writer)
+	}
+	switch {
+	case f.stream == 1 && w.cfg.Stdout != nil:
+		return w.cfg.Stdout
+	case f.stream == 2 && w.cfg.Stderr != nil:
+		return w.cfg.Stderr
+	}
+	return ioutil.Discard
+}
+
+// close closes the files opened by the guest
+func(w *wasiState) close() {
+	for fd, f := range w.files {
+		f.close()
+		delete(w.files, fd)
+	}
+}
+
+// register registers the WASI functions in the runtime, for both WASI versions
+func(w *wasiState) register(r *Runtime) {
+	modules := map[string]map[string]interface{}{
+		wasiModuleName: w.functions(),
+		wasiUnstableModuleName: w.unstableFunctions(),
+	}
+	for moduleName, functions := range modules {
+		for name, fn := range functions {
+			if err := r.RegisterHostFunc(moduleName, name, fn); err != nil {
+				panic(fmt.Sprintf("wasi %s.%s: %s", moduleName, name, err))
+			}
+		}
+	}
+}
+
+// functions returns the WASI functions by name, the ones that aren't supported return ENOSYS
+func(w *wasiState) functions() map[string]interface{} {
+	return map[string]interface{}{
+		"args_get": w.argsGet,
+		"args_sizes_get": w.argsSizesGet,
+		"environ_get": w.environGet,
+		"environ_sizes_get": w.environSizesGet,
+		"clock_res_get": w.clockResGet,
+		"clock_time_get": w.clockTimeGet,
+		"random_get": w.randomGet,
+		"proc_exit": procExit,
+		"proc_raise": func(sig uint32) wasiErrno { return errnoNosys },
+		"sched_yield": func() wasiErrno { return errnoSuccess },
+		"poll_oneoff": w.pollOneoff,
+
+		"fd_advise": func(fd uint32, offset, length uint64, advice uint32) wasiErrno { return w.fdAdvise(fd) },
+		"fd_allocate": func(fd uint32, offset, length uint64) wasiErrno { return errnoNosys },
+		"fd_close": w.fdClose,
+		"fd_datasync": w.fdSync,
+		"fd_fdstat_get": w.fdFdstatGet,
+		"fd_fdstat_set_flags": func(fd, flags uint32) wasiErrno { return errnoNosys },
+		"fd_fdstat_set_rights": func(fd uint32, base, inheriting uint64) wasiErrno { return errnoNosys },
+		"fd_filestat_get": w.fdFilestatGet,
+		"fd_filestat_set_size": w.fdFilestatSetSize,
+		"fd_filestat_set_times": func(fd uint32, atim, mtim uint64, flags uint32) wasiErrno { return errnoNosys },
+		"fd_pread": w.fdPread,
+		"fd_prestat_get": w.fdPrestatGet,
+		"fd_prestat_dir_name": w.fdPrestatDirName,
+		"fd_pwrite": w.fdPwrite,
+		"fd_read": w.fdRead,
+		"fd_readdir": w.fdReaddir,
+		"fd_renumber": w.fdRenumber,
+		"fd_seek": w.fdSeek,
+		"fd_sync": w.fdSync,
+		"fd_tell": w.fdTell,
+		"fd_write": w.fdWrite,
+
+		"path_create_directory": w.pathCreateDirectory,
+		"path_filestat_get": w.pathFilestatGet,
+		"path_filestat_set_times": func(fd, flags, path, pathLen uint32, atim, mtim uint64, fstFlags uint32) wasiErrno { return errnoNosys },
+		"path_link": func(oldFd, oldFlags, oldPath, oldLen, newFd, newPath, newLen uint32) wasiErrno { return errnoNosys },
+		"path_open": w.pathOpen,
+		"path_readlink": func(fd, path, pathLen, buf, bufLen, bufusedPtr uint32) wasiErrno { return errnoNosys },
+		"path_remove_directory": w.pathRemoveDirectory,
+		"path_rename": w.pathRename,
+		"path_symlink": func(oldPath, oldLen, fd, newPath, newLen uint32) wasiErrno { return errnoNosys },
+		"path_unlink_file": w.pathUnlinkFile,
+
+		"sock_recv": func(fd, riData, riDataLen, riFlags, roDataLenPtr, roFlagsPtr uint32) wasiErrno { return errnoNosys },
+		"sock_send": func(fd, siData, siDataLen, siFlags, soDataLenPtr uint32) wasiErrno { return errnoNosys },
+		"sock_shutdown": func(fd, how uint32) wasiErrno { return errnoNosys },
+	}
+}
+
+// wasiMemory wraps the guest memory and keeps the first error, so the functions
+// can do several accesses and return EFAULT once.
+type wasiMemory struct {
+	*Memory
+	err error
+}
+
+func(m *wasiMemory) putUint8(ptr uint32, v uint8) {
+	if m.err == nil {
+		m.err = m.WriteUint8(ptr, v)
+	}
+}
+
+func(m *wasiMemory) putUint32(ptr uint32, v uint32) {
+	if m.err == nil {
+		m.err = m.WriteUint32(ptr, v)
+	}
+}
+
+func(m *wasiMemory) putUint64(ptr uint32, v uint64) {
+	if m.err == nil {
+		m.err = m.WriteUint64(ptr, v)
+	}
+}
+
+func(m *wasiMemory) put(ptr uint32, data []byte) {
+	if m.err == nil {
+		m.err = m.Write(ptr, data)
+	}
+}
+
+func(m *wasiMemory) getUint32(ptr uint32) uint32 {
+	if m.err != nil {
+		return 0
+	}
+	var v uint32
+	v, m.err = m.ReadUint32(ptr)
+	return v
+}
+
+// errno returns EFAULT if an access failed
+func(m *wasiMemory) errno() wasiErrno {
+	if m.err != nil {
+		return errnoFault
+	}
+	return errnoSuccess
+}
+
+func memoryOf(ctx *HostContext) *wasiMemory {
+	return &wasiMemory{Memory: ctx.Memory()}
+}
+
+// putStrings writes NUL-terminated strings to buf and their pointers to ptrs
+func putStrings(ctx *HostContext, list []string, ptrs, buf uint32) wasiErrno {
+	mem := memoryOf(ctx)
+	for i, s := range list {
+		mem.putUint32(ptrs + uint32(i) * 4, buf)
+		mem.put(buf, append([]byte(s), 0))
+		buf += uint32(len(s)) + 1
+	}
+	return mem.errno()
+}
+
+// putSizes writes the number of strings and the size of their NUL-terminated data
+func putSizes(ctx *HostContext, list []string, countPtr, sizePtr uint32) wasiErrno {
+	size := 0
+	for _, s := range list {
+		size += len(s) + 1
+	}
+	mem := memoryOf(ctx)
+	mem.putUint32(countPtr, uint32(len(list)))
+	mem.putUint32(sizePtr, uint32(size))
+	return mem.errno()
+}
+
+func(w *wasiState) argsGet(ctx *HostContext, argv, argvBuf uint32) wasiErrno {
+	return putStrings(ctx, w.args, argv, argvBuf)
+}
+
+func(w *wasiState) argsSizesGet(ctx *HostContext, argcPtr, sizePtr uint32) wasiErrno {
+	return putSizes(ctx, w.args, argcPtr, sizePtr)
+}
+
+func(w *wasiState) environGet(ctx *HostContext, environ, environBuf uint32) wasiErrno {
+	return putStrings(ctx, w.cfg.Env, environ, environBuf)
+}
+
+func(w *wasiState) environSizesGet(ctx *HostContext, countPtr, sizePtr uint32) wasiErrno {
+	return putSizes(ctx, w.cfg.Env, countPtr, sizePtr)
+}
+
+func(w *wasiState) randomGet(ctx *HostContext, buf, length uint32) wasiErrno {
+	b, err := ctx.Memory().slice(buf, length)
+	if err != nil {
+		return errnoFault
+	}
+	source := w.cfg.Rand
+	if source == nil {
+		source = rand.Reader
+	}
+	if _, err := io.ReadFull(source, b); err != nil {
+		return errnoIO
+	}
+	return errnoSuccess
+}
+
+// procExit stops the guest, the call returns an *ExitError
+func procExit(code uint32) error {
+	return &ExitError{Code: code}
+}
@@ -0,0 +1,237 @@
+package wasm3
+
+import(
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+)
+
+const(
+	clockRealtime = 0
+	clockMonotonic = 1
+)
+
+// poll_oneoff layouts
+const(
+	subscriptionSize = 48
+	eventSize = 32
+
+	eventtypeClock = 0
+	eventtypeFDRead = 1
+	eventtypeFDWrite = 2
+
+	subclockAbstime = 1 << 0
+)
+
+// Clock is the time source of the WASI guest
+type Clock interface {
+	// Now returns the wall clock time
+	Now() time.Time
+	// Nanotime returns the monotonic time in nanoseconds
+	Nanotime() uint64
+	// Resolution returns the precision of both clocks
+	Resolution() time.Duration
+	// Sleep is called by poll_oneoff to wait for the timeouts. CallContext only interrupts the
+	// sleeps of the system clock, other clocks shouldn't block.
+	Sleep(d time.Duration)
+}
+
+// systemClock is the default Clock, the monotonic time starts when the runtime is created
+type systemClock struct {
+	start time.Time
+}
+
+func(c *systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func(c *systemClock) Nanotime() uint64 {
+	return uint64(time.Since(c.start))
+}
+
+func(c *systemClock) Resolution() time.Duration {
+	return time.Nanosecond
+}
+
+func(c *systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// FakeClock is a deterministic Clock. Time only moves forward when the guest sleeps, which
+// returns immediately, and by a fixed step on every reading so busy loops still progress.
+type FakeClock struct {
+	mu sync.Mutex
+	start time.Time
+	elapsed time.Duration
+	step time.Duration
+}
+
+// NewFakeClock returns a FakeClock whose wall time starts at start and advances step on every reading
+func NewFakeClock(start time.Time, step time.Duration) *FakeClock {
+	return &FakeClock{start: start, step: step}
+}
+
+// read returns the elapsed time and advances the clock by step
+func(c *FakeClock) read() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elapsed := c.elapsed
+	c.elapsed += c.step
+	return elapsed
+}
+
+func(c *FakeClock) Now() time.Time {
+	return c.start.Add(c.read())
+}
+
+func(c *FakeClock) Nanotime() uint64 {
+	return uint64(c.read())
+}
+
+func(c *FakeClock) Resolution() time.Duration {
+	return time.Nanosecond
+}
+
+// Sleep advances the clock by d without blocking, hosts can also use it to move the time forward
+func(c *FakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.elapsed += d
+}
+
+func(w *wasiState) clockResGet(ctx *HostContext, id, resPtr uint32) wasiErrno {
+	if id != clockRealtime && id != clockMonotonic {
+		return errnoInval
+	}
+	mem := memoryOf(ctx)
+	mem.putUint64(resPtr, uint64(w.clock.Resolution()))
+	return mem.errno()
+}
+
+func(w *wasiState) clockTimeGet(ctx *HostContext, id uint32, precision uint64, timePtr uint32) wasiErrno {
+	var t uint64
+	switch id {
+	case clockRealtime:
+		t = uint64(w.clock.Now().UnixNano())
+	case clockMonotonic:
+		t = w.clock.Nanotime()
+	default:
+		return errnoInval
+	}
+	mem := memoryOf(ctx)
+	mem.putUint64(timePtr, t)
+	return mem.errno()
+}
+
+// timeout returns how long a clock subscription has to wait, the clock is only read for absolute times
+func(w *wasiState) timeout(id uint32, t uint64, flags uint16) (time.Duration, wasiErrno) {
+	if id != clockRealtime && id != clockMonotonic {
+		return 0, errnoInval
+	}
+	if flags & subclockAbstime != 0 {
+		now := w.clock.Nanotime()
+		if id == clockRealtime {
+			now = uint64(w.clock.Now().UnixNano())
+		}
+		if t <= now {
+			return 0, errnoSuccess
+		}
+		t -= now
+	}
+	if t > math.MaxInt64 {
+		t = math.MaxInt64
+	}
+	return time.Duration(t), errnoSuccess
+}
+
+func newEvent(userdata uint64, errno wasiErrno, eventtype uint8) []byte {
+	event := make([]byte, eventSize)
+	binary.LittleEndian.PutUint64(event, userdata)
+	binary.LittleEndian.PutUint16(event[8:], uint16(errno))
+	event[10] = eventtype
+	return event
+}
+
+// pollOneoff reports the file subscriptions as ready right away, files never block.
+// Otherwise it sleeps until the earliest timeout and reports the clocks that expired.
+// An interrupted sleep traps with ErrInterrupted.
+func(w *wasiState) pollOneoff(ctx *HostContext, in, out, n, neventsPtr uint32) (wasiErrno, error) {
+	if n == 0 {
+		return errnoInval, nil
+	}
+	if n > math.MaxUint32 / subscriptionSize {
+		return errnoFault, nil
+	}
+	subs, err := ctx.Memory().Read(in, n * subscriptionSize)
+	if err != nil {
+		return errnoFault, nil
+	}
+	return w.poll(ctx, subs, out, neventsPtr)
+}
+
+// poll handles the subscriptions of pollOneoff
+func(w *wasiState) poll(ctx *HostContext, subs []byte, out, neventsPtr uint32) (wasiErrno, error) {
+	type timer struct {
+		userdata uint64
+		timeout time.Duration
+	}
+	var events []byte
+	var timers []timer
+	for i := 0; i < len(subs); i += subscriptionSize {
+		sub := subs[i:]
+		userdata := binary.LittleEndian.Uint64(sub)
+		switch eventtype := sub[8]; eventtype {
+		case eventtypeClock:
+			d, errno := w.timeout(binary.LittleEndian.Uint32(sub[16:]), binary.LittleEndian.Uint64(sub[24:]),
+				binary.LittleEndian.Uint16(sub[40:]))
+			if errno != errnoSuccess {
+				events = append(events, newEvent(userdata, errno, eventtype)...)
+				continue
+			}
+			timers = append(timers, timer{userdata, d})
+		case eventtypeFDRead, eventtypeFDWrite:
+			_, errno := w.lookup(binary.LittleEndian.Uint32(sub[16:]))
+			events = append(events, newEvent(userdata, errno, eventtype)...)
+		default:
+			events = append(events, newEvent(userdata, errnoInval, eventtype)...)
+		}
+	}
+	if len(events) == 0 {
+		earliest := timers[0].timeout
+		for _, t := range timers {
+			if t.timeout < earliest {
+				earliest = t.timeout
+			}
+		}
+		if err := w.sleep(ctx, earliest); err != nil {
+			return errnoSuccess, err
+		}
+		for _, t := range timers {
+			if t.timeout == earliest {
+				events = append(events, newEvent(t.userdata, errnoSuccess, eventtypeClock)...)
+			}
+		}
+	}
+	mem := memoryOf(ctx)
+	mem.put(out, events)
+	mem.putUint32(neventsPtr, uint32(len(events) / eventSize))
+	return mem.errno(), nil
+}
+
+// sleep waits on the clock, sleeps on the system clock return ErrInterrupted when
+// CallContext interrupts the call
+func(w *wasiState) sleep(ctx *HostContext, d time.Duration) error {
+	if _, ok := w.clock.(*systemClock); !ok {
+		w.clock.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Runtime.interrupt:
+		return ErrInterrupted
+	}
+}
@@ -0,0 +1,774 @@
+package wasm3
+
+import(
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// WASI file types
+const(
+	filetypeUnknown uint8 = 0
+	filetypeCharacterDevice uint8 = 2
+	filetypeDirectory uint8 = 3
+	filetypeRegularFile uint8 = 4
+	filetypeSymbolicLink uint8 = 7
+)
+
+// path_open flags
+const(
+	oflagCreat = 1 << 0
+	oflagDirectory = 1 << 1
+	oflagExcl = 1 << 2
+	oflagTrunc = 1 << 3
+
+	fdflagAppend = 1 << 0
+)
+
+// WASI rights, rightsWrite are the ones removed from read-only files
+const(
+	rightFDDatasync = 1 << 0
+	rightFDRead = 1 << 1
+	rightFDWrite = 1 << 6
+	rightFDAllocate = 1 << 8
+	rightPathCreateDirectory = 1 << 9
+	rightPathCreateFile = 1 << 10
+	rightPathLinkTarget = 1 << 12
+	rightPathRenameSource = 1 << 16
+	rightPathRenameTarget = 1 << 17
+	rightPathFilestatSetSize = 1 << 19
+	rightFDFilestatSetSize = 1 << 22
+	rightPathSymlink = 1 << 24
+	rightPathRemoveDirectory = 1 << 25
+	rightPathUnlinkFile = 1 << 26
+
+	rightsAll = 1 << 29 - 1
+	rightsWrite = rightFDDatasync | rightFDWrite | rightFDAllocate | rightPathCreateDirectory |
+		rightPathCreateFile | rightPathLinkTarget | rightPathRenameSource | rightPathRenameTarget |
+		rightPathFilestatSetSize | rightFDFilestatSetSize | rightPathSymlink | rightPathRemoveDirectory |
+		rightPathUnlinkFile
+)
+
+const(
+	// direntSize is the size of a dirent without the name
+	direntSize = 24
+	// filestatSize is the size of a filestat
+	filestatSize = 64
+)
+
+// wasiMount is a filesystem mounted at a guest path
+type wasiMount struct {
+	guestPath string
+	fsys fs.FS
+}
+
+// wasiFile is an open file descriptor
+type wasiFile struct {
+	// file is nil for the standard streams and the directories
+	file fs.File
+	stdio bool
+	// stream is the standard stream number (0-2) of stdio files
+	stream uint32
+	// preopen is set on the root directories of the mounts
+	preopen bool
+	// mount is the filesystem the file belongs to and name its path there
+	mount *wasiMount
+	name string
+	dir bool
+	readable bool
+	writable bool
+	// entries are the directory entries read by fd_readdir
+	entries []fs.DirEntry
+}
+
+// Optional methods of the files
+type(
+	syncer interface {
+		Sync() error
+	}
+	truncater interface {
+		Truncate(size int64) error
+	}
+	lstater interface {
+		Lstat(name string) (fs.FileInfo, error)
+	}
+)
+
+func(f *wasiFile) close() {
+	if f.file != nil {
+		f.file.Close()
+	}
+}
+
+func(f *wasiFile) filetype() uint8 {
+	switch {
+	case f.stdio:
+		return filetypeCharacterDevice
+	case f.dir:
+		return filetypeDirectory
+	}
+	return filetypeRegularFile
+}
+
+// errnoOf maps Go errors to WASI error codes
+func errnoOf(err error) wasiErrno {
+	switch {
+	case err == nil:
+		return errnoSuccess
+	case errors.Is(err, errPathEscapes):
+		return errnoNotcapable
+	case errors.Is(err, fs.ErrNotExist):
+		return errnoNoent
+	case errors.Is(err, fs.ErrExist):
+		return errnoExist
+	case errors.Is(err, fs.ErrPermission):
+		return errnoAcces
+	case errors.Is(err, fs.ErrInvalid):
+		return errnoInval
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EBADF:
+			return errnoBadf
+		case syscall.EINVAL:
+			return errnoInval
+		case syscall.EISDIR:
+			return errnoIsdir
+		case syscall.ELOOP:
+			return errnoLoop
+		case syscall.ENAMETOOLONG:
+			return errnoNametoolong
+		case syscall.ENOTDIR:
+			return errnoNotdir
+		case syscall.ENOTEMPTY:
+			return errnoNotempty
+		case syscall.ESPIPE:
+			return errnoSpipe
+		}
+	}
+	return errnoIO
+}
+
+func filetypeOf(mode fs.FileMode) uint8 {
+	switch {
+	case mode.IsRegular():
+		return filetypeRegularFile
+	case mode.IsDir():
+		return filetypeDirectory
+	case mode & fs.ModeSymlink != 0:
+		return filetypeSymbolicLink
+	case mode & fs.ModeCharDevice != 0:
+		return filetypeCharacterDevice
+	}
+	return filetypeUnknown
+}
+
+// filestat returns the filestat of fi
+func filestat(fi fs.FileInfo) []byte {
+	buf := make([]byte, filestatSize)
+	buf[16] = filetypeOf(fi.Mode())
+	binary.LittleEndian.PutUint64(buf[24:], 1)
+	binary.LittleEndian.PutUint64(buf[32:], uint64(fi.Size()))
+	t := uint64(fi.ModTime().UnixNano())
+	binary.LittleEndian.PutUint64(buf[40:], t)
+	binary.LittleEndian.PutUint64(buf[48:], t)
+	binary.LittleEndian.PutUint64(buf[56:], t)
+	return buf
+}
+
+// lookup returns an open fd
+func(w *wasiState) lookup(fd uint32) (*wasiFile, wasiErrno) {
+	f, ok := w.files[fd]
+	if !ok {
+		return nil, errnoBadf
+	}
+	return f, errnoSuccess
+}
+
+// lstat doesn't follow symlinks when the filesystem supports them, so they can be removed
+func lstat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if l, ok := fsys.(lstater); ok {
+		return l.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// readOnly reports whether the guest can't modify the filesystem of f
+func(w *wasiState) readOnly(f *wasiFile) bool {
+	_, writable := f.mount.fsys.(WritableFS)
+	return w.cfg.ReadOnly || !writable
+}
+
+// resolve returns the path of a guest path relative to the directory fd in its mount.
+// Absolute paths and paths that escape the mount are rejected.
+func(w *wasiState) resolve(ctx *HostContext, fd, pathPtr, pathLen uint32) (*wasiFile, string, wasiErrno) {
+	dir, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return nil, "", errno
+	}
+	if !dir.dir {
+		return nil, "", errnoNotdir
+	}
+	p, err := ctx.Memory().ReadString(pathPtr, pathLen)
+	if err != nil {
+		return nil, "", errnoFault
+	}
+	if p == "" {
+		return nil, "", errnoNoent
+	}
+	if strings.IndexByte(p, 0) >= 0 {
+		return nil, "", errnoInval
+	}
+	name := path.Join(dir.name, p)
+	if path.IsAbs(p) || name == ".." || strings.HasPrefix(name, "../") {
+		return nil, "", errnoNotcapable
+	}
+	return dir, name, errnoSuccess
+}
+
+// nextFD returns the lowest free fd
+func(w *wasiState) nextFD() uint32 {
+	fd := uint32(3)
+	for w.files[fd] != nil {
+		fd++
+	}
+	return fd
+}
+
+func(w *wasiState) fdAdvise(fd uint32) wasiErrno {
+	_, errno := w.lookup(fd)
+	return errno
+}
+
+func(w *wasiState) fdClose(fd uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	f.close()
+	delete(w.files, fd)
+	return errnoSuccess
+}
+
+func(w *wasiState) fdSync(fd uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if s, ok := f.file.(syncer); ok && !f.stdio {
+		return errnoOf(s.Sync())
+	}
+	return errnoSuccess
+}
+
+func(w *wasiState) fdFdstatGet(ctx *HostContext, fd, buf uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	rights := uint64(rightsAll)
+	if (!f.stdio && w.readOnly(f)) || (!f.dir && !f.writable) {
+		rights &^= rightsWrite
+	}
+	mem := memoryOf(ctx)
+	mem.put(buf, make([]byte, 24))
+	mem.putUint8(buf, f.filetype())
+	mem.putUint64(buf + 8, rights)
+	mem.putUint64(buf + 16, rights)
+	return mem.errno()
+}
+
+func(w *wasiState) fdFilestatGet(ctx *HostContext, fd, buf uint32) wasiErrno {
+	stat, errno := w.fdFilestat(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	mem := memoryOf(ctx)
+	mem.put(buf, stat)
+	return mem.errno()
+}
+
+// fdFilestat returns the filestat of fd
+func(w *wasiState) fdFilestat(fd uint32) ([]byte, wasiErrno) {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return nil, errno
+	}
+	if f.stdio {
+		stat := make([]byte, filestatSize)
+		stat[16] = filetypeCharacterDevice
+		return stat, errnoSuccess
+	}
+	var fi fs.FileInfo
+	var err error
+	if f.file != nil {
+		fi, err = f.file.Stat()
+	} else {
+		fi, err = fs.Stat(f.mount.fsys, f.name)
+	}
+	if err != nil {
+		return nil, errnoOf(err)
+	}
+	return filestat(fi), errnoSuccess
+}
+
+func(w *wasiState) fdFilestatSetSize(fd uint32, size uint64) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if !f.writable || f.stdio {
+		return errnoBadf
+	}
+	t, ok := f.file.(truncater)
+	if !ok {
+		return errnoNosys
+	}
+	return errnoOf(t.Truncate(int64(size)))
+}
+
+func(w *wasiState) fdPrestatGet(ctx *HostContext, fd, buf uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if !f.preopen {
+		return errnoBadf
+	}
+	mem := memoryOf(ctx)
+	mem.putUint32(buf, 0)
+	mem.putUint32(buf + 4, uint32(len(f.mount.guestPath)))
+	return mem.errno()
+}
+
+func(w *wasiState) fdPrestatDirName(ctx *HostContext, fd, pathPtr, pathLen uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if !f.preopen {
+		return errnoBadf
+	}
+	if pathLen < uint32(len(f.mount.guestPath)) {
+		return errnoNametoolong
+	}
+	mem := memoryOf(ctx)
+	mem.put(pathPtr, []byte(f.mount.guestPath))
+	return mem.errno()
+}
+
+// iovecs returns the guest buffers of an iovec array
+func iovecs(ctx *HostContext, iovs, iovsLen uint32) ([][]byte, wasiErrno) {
+	mem := memoryOf(ctx)
+	bufs := make([][]byte, 0, iovsLen)
+	for i := uint32(0); i < iovsLen; i++ {
+		ptr := mem.getUint32(iovs + i * 8)
+		length := mem.getUint32(iovs + i * 8 + 4)
+		if mem.err != nil {
+			return nil, errnoFault
+		}
+		b, err := mem.slice(ptr, length)
+		if err != nil {
+			return nil, errnoFault
+		}
+		bufs = append(bufs, b)
+	}
+	return bufs, errnoSuccess
+}
+
+// readv fills the buffers, it stops at the first short read
+func readv(bufs [][]byte, read func([]byte) (int, error)) (uint32, wasiErrno) {
+	total := uint32(0)
+	for _, b := range bufs {
+		n, err := read(b)
+		total += uint32(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, errnoOf(err)
+		}
+		if n < len(b) {
+			break
+		}
+	}
+	return total, errnoSuccess
+}
+
+func writev(bufs [][]byte, write func([]byte) (int, error)) (uint32, wasiErrno) {
+	total := uint32(0)
+	for _, b := range bufs {
+		n, err := write(b)
+		total += uint32(n)
+		if err != nil {
+			return total, errnoOf(err)
+		}
+	}
+	return total, errnoSuccess
+}
+
+func(w *wasiState) fdRead(ctx *HostContext, fd, iovs, iovsLen, nreadPtr uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.dir {
+		return errnoIsdir
+	}
+	if !f.readable {
+		return errnoBadf
+	}
+	bufs, errno := iovecs(ctx, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	n, errno := readv(bufs, w.reader(f).Read)
+	mem := memoryOf(ctx)
+	mem.putUint32(nreadPtr, n)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return mem.errno()
+}
+
+func(w *wasiState) fdPread(ctx *HostContext, fd, iovs, iovsLen uint32, offset uint64, nreadPtr uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.dir {
+		return errnoIsdir
+	}
+	if !f.readable || f.stdio {
+		return errnoBadf
+	}
+	r, ok := f.file.(io.ReaderAt)
+	if !ok {
+		return errnoSpipe
+	}
+	bufs, errno := iovecs(ctx, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	n, errno := readv(bufs, func(b []byte) (int, error) {
+		n, err := r.ReadAt(b, int64(offset))
+		offset += uint64(n)
+		return n, err
+	})
+	mem := memoryOf(ctx)
+	mem.putUint32(nreadPtr, n)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return mem.errno()
+}
+
+func(w *wasiState) fdWrite(ctx *HostContext, fd, iovs, iovsLen, nwrittenPtr uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.dir {
+		return errnoIsdir
+	}
+	if !f.writable {
+		return errnoBadf
+	}
+	bufs, errno := iovecs(ctx, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	n, errno := writev(bufs, w.writer(f).Write)
+	mem := memoryOf(ctx)
+	mem.putUint32(nwrittenPtr, n)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return mem.errno()
+}
+
+func(w *wasiState) fdPwrite(ctx *HostContext, fd, iovs, iovsLen uint32, offset uint64, nwrittenPtr uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.dir {
+		return errnoIsdir
+	}
+	if !f.writable || f.stdio {
+		return errnoBadf
+	}
+	wa, ok := f.file.(io.WriterAt)
+	if !ok {
+		return errnoSpipe
+	}
+	bufs, errno := iovecs(ctx, iovs, iovsLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	n, errno := writev(bufs, func(b []byte) (int, error) {
+		n, err := wa.WriteAt(b, int64(offset))
+		offset += uint64(n)
+		return n, err
+	})
+	mem := memoryOf(ctx)
+	mem.putUint32(nwrittenPtr, n)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return mem.errno()
+}
+
+func(w *wasiState) fdSeek(ctx *HostContext, fd uint32, offset int64, whence, newOffsetPtr uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if f.dir {
+		return errnoIsdir
+	}
+	seeker, ok := f.file.(io.Seeker)
+	if f.stdio || !ok {
+		return errnoSpipe
+	}
+	if whence > io.SeekEnd {
+		return errnoInval
+	}
+	pos, err := seeker.Seek(offset, int(whence))
+	if err != nil {
+		return errnoOf(err)
+	}
+	mem := memoryOf(ctx)
+	mem.putUint64(newOffsetPtr, uint64(pos))
+	return mem.errno()
+}
+
+func(w *wasiState) fdTell(ctx *HostContext, fd, offsetPtr uint32) wasiErrno {
+	return w.fdSeek(ctx, fd, 0, io.SeekCurrent, offsetPtr)
+}
+
+// fdReaddir writes the directory entries starting at cookie, the last one is truncated
+// when the buffer is full as the WASI ABI expects.
+func(w *wasiState) fdReaddir(ctx *HostContext, fd, buf, bufLen uint32, cookie uint64, bufusedPtr uint32) wasiErrno {
+	f, errno := w.lookup(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if !f.dir {
+		return errnoNotdir
+	}
+	if cookie == 0 || f.entries == nil {
+		entries, err := fs.ReadDir(f.mount.fsys, f.name)
+		if err != nil {
+			return errnoOf(err)
+		}
+		f.entries = entries
+	}
+	var out []byte
+	for i := cookie; i < uint64(len(f.entries)) && uint32(len(out)) < bufLen; i++ {
+		name := f.entries[i].Name()
+		dirent := make([]byte, direntSize, direntSize + len(name))
+		binary.LittleEndian.PutUint64(dirent, i + 1)
+		binary.LittleEndian.PutUint32(dirent[16:], uint32(len(name)))
+		dirent[20] = filetypeOf(f.entries[i].Type())
+		out = append(out, append(dirent, name...)...)
+	}
+	if uint32(len(out)) > bufLen {
+		out = out[:bufLen]
+	}
+	mem := memoryOf(ctx)
+	mem.put(buf, out)
+	mem.putUint32(bufusedPtr, uint32(len(out)))
+	return mem.errno()
+}
+
+// fdRenumber moves the fd from over to, the preopened directories can't be replaced
+func(w *wasiState) fdRenumber(from, to uint32) wasiErrno {
+	f, errno := w.lookup(from)
+	if errno != errnoSuccess {
+		return errno
+	}
+	old, errno := w.lookup(to)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if from == to {
+		return errnoSuccess
+	}
+	if old.preopen {
+		return errnoNotsup
+	}
+	old.close()
+	w.files[to] = f
+	delete(w.files, from)
+	return errnoSuccess
+}
+
+func(w *wasiState) pathOpen(ctx *HostContext, fd, dirflags, pathPtr, pathLen, oflags uint32,
+	rightsBase, rightsInheriting uint64, fdflags, fdPtr uint32) wasiErrno {
+	dir, name, errno := w.resolve(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	f := &wasiFile{
+		mount: dir.mount,
+		name: name,
+		readable: rightsBase & rightFDRead != 0,
+		writable: rightsBase & rightFDWrite != 0,
+	}
+	create := oflags & (oflagCreat | oflagTrunc) != 0
+	if w.readOnly(dir) && (f.writable || create) {
+		return errnoRofs
+	}
+	fi, err := fs.Stat(dir.mount.fsys, name)
+	switch {
+	case err == nil && fi.IsDir():
+		if f.writable || create {
+			return errnoIsdir
+		}
+		f.dir = true
+	case oflags & oflagDirectory != 0:
+		if err == nil {
+			return errnoNotdir
+		}
+		return errnoOf(err)
+	case f.writable || create:
+		flags := os.O_WRONLY
+		if f.readable {
+			flags = os.O_RDWR
+		}
+		if !f.writable {
+			flags = os.O_RDONLY
+		}
+		if oflags & oflagCreat != 0 {
+			flags |= os.O_CREATE
+		}
+		if oflags & oflagExcl != 0 {
+			flags |= os.O_EXCL
+		}
+		if oflags & oflagTrunc != 0 {
+			flags |= os.O_TRUNC
+		}
+		if fdflags & fdflagAppend != 0 {
+			flags |= os.O_APPEND
+		}
+		file, err := dir.mount.fsys.(WritableFS).OpenFile(name, flags, 0644)
+		if err != nil {
+			return errnoOf(err)
+		}
+		if _, ok := file.(io.Writer); f.writable && !ok {
+			file.Close()
+			return errnoRofs
+		}
+		f.file = file
+	default:
+		file, err := dir.mount.fsys.Open(name)
+		if err != nil {
+			return errnoOf(err)
+		}
+		f.file = file
+	}
+	newFD := w.nextFD()
+	mem := memoryOf(ctx)
+	mem.putUint32(fdPtr, newFD)
+	if mem.err != nil {
+		f.close()
+		return errnoFault
+	}
+	w.files[newFD] = f
+	return errnoSuccess
+}
+
+func(w *wasiState) pathFilestatGet(ctx *HostContext, fd, flags, pathPtr, pathLen, buf uint32) wasiErrno {
+	stat, errno := w.pathFilestat(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	mem := memoryOf(ctx)
+	mem.put(buf, stat)
+	return mem.errno()
+}
+
+// pathFilestat returns the filestat of a path relative to the directory fd
+func(w *wasiState) pathFilestat(ctx *HostContext, fd, pathPtr, pathLen uint32) ([]byte, wasiErrno) {
+	dir, name, errno := w.resolve(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return nil, errno
+	}
+	fi, err := fs.Stat(dir.mount.fsys, name)
+	if err != nil {
+		return nil, errnoOf(err)
+	}
+	return filestat(fi), errnoSuccess
+}
+
+// modify resolves a path that is about to be created, renamed or removed
+func(w *wasiState) modify(ctx *HostContext, fd, pathPtr, pathLen uint32) (*wasiMount, string, wasiErrno) {
+	dir, name, errno := w.resolve(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return nil, "", errno
+	}
+	if w.readOnly(dir) {
+		return nil, "", errnoRofs
+	}
+	return dir.mount, name, errnoSuccess
+}
+
+func(w *wasiState) pathCreateDirectory(ctx *HostContext, fd, pathPtr, pathLen uint32) wasiErrno {
+	mount, name, errno := w.modify(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	return errnoOf(mount.fsys.(WritableFS).Mkdir(name, 0755))
+}
+
+func(w *wasiState) pathRemoveDirectory(ctx *HostContext, fd, pathPtr, pathLen uint32) wasiErrno {
+	mount, name, errno := w.modify(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	fi, err := lstat(mount.fsys, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if !fi.IsDir() {
+		return errnoNotdir
+	}
+	return errnoOf(mount.fsys.(WritableFS).Remove(name))
+}
+
+func(w *wasiState) pathUnlinkFile(ctx *HostContext, fd, pathPtr, pathLen uint32) wasiErrno {
+	mount, name, errno := w.modify(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	fi, err := lstat(mount.fsys, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+	if fi.IsDir() {
+		return errnoIsdir
+	}
+	return errnoOf(mount.fsys.(WritableFS).Remove(name))
+}
+
+func(w *wasiState) pathRename(ctx *HostContext, fd, oldPath, oldLen, newFd, newPath, newLen uint32) wasiErrno {
+	from, oldName, errno := w.modify(ctx, fd, oldPath, oldLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	to, newName, errno := w.modify(ctx, newFd, newPath, newLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	if from != to {
+		return errnoXdev
+	}
+	return errnoOf(from.fsys.(WritableFS).Rename(oldName, newName))
+}
@@ -0,0 +1,140 @@
+package wasm3
+
+import(
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// errPathEscapes is returned by DirFS for paths that leave the directory through symlinks,
+// or go through symlinks that can't be resolved
+var errPathEscapes = errors.New("Path escapes the directory")
+
+// WritableFS is a filesystem the guest can modify, paths are slash-separated and unrooted like in fs.FS.
+// Files opened for writing must implement io.Writer, they may also implement io.WriterAt, io.Seeker,
+// Truncate(int64) error and Sync() error. Filesystems that only implement fs.FS are read-only.
+type WritableFS interface {
+	fs.FS
+	OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// dirFS is a host directory
+type dirFS string
+
+// DirFS returns a writable filesystem for a host directory, unlike os.DirFS it doesn't follow
+// symlinks that point outside of it, or dangling symlinks. The Preopens of WASIConfig are mounted with it.
+func DirFS(dir string) WritableFS {
+	return dirFS(dir)
+}
+
+// join returns the host path of name with the symlinks of its directory resolved. With follow,
+// a final symlink is resolved too, it's rejected when its target can't be resolved inside the
+// directory (e.g. it's dangling). Otherwise the final component is returned as is, so the
+// operation applies to the symlink itself.
+func(d dirFS) join(op, name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := filepath.EvalSymlinks(string(d))
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if name == "." {
+		return root, nil
+	}
+	hostPath := filepath.Join(string(d), filepath.FromSlash(name))
+	parent, err := filepath.EvalSymlinks(filepath.Dir(hostPath))
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if !within(root, parent) {
+		return "", &fs.PathError{Op: op, Path: name, Err: errPathEscapes}
+	}
+	hostPath = filepath.Join(parent, filepath.Base(hostPath))
+	if !follow {
+		return hostPath, nil
+	}
+	fi, err := os.Lstat(hostPath)
+	if err != nil || fi.Mode() & fs.ModeSymlink == 0 {
+		return hostPath, nil
+	}
+	target, err := filepath.EvalSymlinks(hostPath)
+	if err != nil || !within(root, target) {
+		return "", &fs.PathError{Op: op, Path: name, Err: errPathEscapes}
+	}
+	return target, nil
+}
+
+func(d dirFS) Open(name string) (fs.File, error) {
+	return d.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func(d dirFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	hostPath, err := d.join("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	// The path was resolved, O_NOFOLLOW fails if a symlink replaced it in the meantime
+	file, err := os.OpenFile(hostPath, flag | syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func(d dirFS) Stat(name string) (fs.FileInfo, error) {
+	hostPath, err := d.join("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(hostPath)
+}
+
+// Lstat doesn't follow the last symlink of name
+func(d dirFS) Lstat(name string) (fs.FileInfo, error) {
+	hostPath, err := d.join("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(hostPath)
+}
+
+func(d dirFS) Mkdir(name string, perm fs.FileMode) error {
+	hostPath, err := d.join("mkdir", name, false)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(hostPath, perm)
+}
+
+func(d dirFS) Remove(name string) error {
+	hostPath, err := d.join("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return os.Remove(hostPath)
+}
+
+func(d dirFS) Rename(oldname, newname string) error {
+	from, err := d.join("rename", oldname, false)
+	if err != nil {
+		return err
+	}
+	to, err := d.join("rename", newname, false)
+	if err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// within reports whether the resolved hostPath is root or inside of it
+func within(root, hostPath string) bool {
+	rel, err := filepath.Rel(root, hostPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".." + string(filepath.Separator))
+}
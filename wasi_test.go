@@ -0,0 +1,607 @@
+package wasm3
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// wasiImports are the WASI functions used by the tests, they're exported unchanged
+var wasiImports = []testImport{
+	{field: "args_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "args_sizes_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "environ_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "environ_sizes_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "fd_prestat_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "fd_prestat_dir_name", params: []byte{i32, i32, i32}, results: []byte{i32}},
+	{field: "path_open", params: []byte{i32, i32, i32, i32, i32, i64, i64, i32, i32}, results: []byte{i32}},
+	{field: "fd_read", params: []byte{i32, i32, i32, i32}, results: []byte{i32}},
+	{field: "fd_write", params: []byte{i32, i32, i32, i32}, results: []byte{i32}},
+	{field: "fd_seek", params: []byte{i32, i64, i32, i32}, results: []byte{i32}},
+	{field: "fd_close", params: []byte{i32}, results: []byte{i32}},
+	{field: "fd_renumber", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "fd_readdir", params: []byte{i32, i32, i32, i64, i32}, results: []byte{i32}},
+	{field: "fd_filestat_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "path_create_directory", params: []byte{i32, i32, i32}, results: []byte{i32}},
+	{field: "path_unlink_file", params: []byte{i32, i32, i32}, results: []byte{i32}},
+	{field: "clock_res_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "clock_time_get", params: []byte{i32, i64, i32}, results: []byte{i32}},
+	{field: "random_get", params: []byte{i32, i32}, results: []byte{i32}},
+	{field: "poll_oneoff", params: []byte{i32, i32, i32, i32}, results: []byte{i32}},
+	{field: "proc_exit", params: []byte{i32}},
+}
+
+func wasiTestModule(moduleName string) *testModule {
+	m := &testModule{memory: &testMemory{min: 1}}
+	for _, imp := range wasiImports {
+		imp.module = moduleName
+		index := m.addImport(imp)
+		var code []byte
+		for i := range imp.params {
+			code = append(code, 0x20, byte(i))
+		}
+		m.funcs = append(m.funcs, testFunc{export: imp.field, params: imp.params, results: imp.results,
+			code: append(code, 0x10, byte(index))})
+	}
+	return m
+}
+
+func loadWASITestModule(t *testing.T, cfg *WASIConfig) *Runtime {
+	t.Helper()
+	return loadWASIModule(t, cfg, wasiModuleName)
+}
+
+// loadWASIModule loads the test module with the WASI functions imported from moduleName
+func loadWASIModule(t *testing.T, cfg *WASIConfig, moduleName string) *Runtime {
+	t.Helper()
+	runtime := NewRuntime(&Config{
+		Environment:   NewEnvironment(),
+		StackSize:     64 * 1024,
+		StrictImports: true,
+		WASI:          cfg,
+	})
+	if _, err := runtime.Load(wasiTestModule(moduleName).bytes()); err != nil {
+		runtime.Destroy()
+		t.Fatal(err)
+	}
+	return runtime
+}
+
+// wasiCall calls a WASI function and checks the errno
+func wasiCall(t *testing.T, runtime *Runtime, expected wasiErrno, name string, args ...interface{}) {
+	t.Helper()
+	if errno := callFunction(t, runtime, name, args...); errno != int32(expected) {
+		t.Fatalf("%s returned errno %v, expected %d", name, errno, expected)
+	}
+}
+
+func readUint32(t *testing.T, runtime *Runtime, ptr uint32) uint32 {
+	t.Helper()
+	v, err := runtime.Memory().ReadUint32(ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestWASIArgsAndEnv(t *testing.T) {
+	runtime := loadWASITestModule(t, &WASIConfig{
+		Args: []string{"prog", "-v"},
+		Env:  []string{"HOME=/home/guest"},
+	})
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	wasiCall(t, runtime, errnoSuccess, "args_sizes_get", 0, 4)
+	if argc, size := readUint32(t, runtime, 0), readUint32(t, runtime, 4); argc != 2 || size != 8 {
+		t.Fatalf("Unexpected sizes: argc=%d, size=%d", argc, size)
+	}
+	wasiCall(t, runtime, errnoSuccess, "args_get", 16, 64)
+	for i, expected := range []string{"prog", "-v"} {
+		s, err := mem.ReadCString(readUint32(t, runtime, 16+uint32(i)*4))
+		if err != nil || s != expected {
+			t.Fatalf("Unexpected argument %d: %q, %v", i, s, err)
+		}
+	}
+	wasiCall(t, runtime, errnoSuccess, "environ_sizes_get", 0, 4)
+	if count, size := readUint32(t, runtime, 0), readUint32(t, runtime, 4); count != 1 || size != 17 {
+		t.Fatalf("Unexpected sizes: count=%d, size=%d", count, size)
+	}
+	wasiCall(t, runtime, errnoSuccess, "environ_get", 16, 64)
+	if s, err := mem.ReadCString(readUint32(t, runtime, 16)); err != nil || s != "HOME=/home/guest" {
+		t.Fatalf("Unexpected variable: %q, %v", s, err)
+	}
+	wasiCall(t, runtime, errnoFault, "args_get", 16, mem.Size())
+}
+
+// openFile opens path relative to the directory fd and returns the new fd
+func openFile(t *testing.T, runtime *Runtime, expected wasiErrno, dirFD uint32, path string, oflags uint32, rights uint64) uint32 {
+	t.Helper()
+	if err := runtime.Memory().Write(1024, []byte(path)); err != nil {
+		t.Fatal(err)
+	}
+	wasiCall(t, runtime, expected, "path_open", dirFD, 0, 1024, len(path), oflags, rights, 0, 0, 2048)
+	if expected != errnoSuccess {
+		return 0
+	}
+	return readUint32(t, runtime, 2048)
+}
+
+func TestWASIFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	runtime := loadWASITestModule(t, &WASIConfig{Preopens: map[string]string{"/data": dir}})
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	wasiCall(t, runtime, errnoSuccess, "fd_prestat_get", 3, 0)
+	if length := readUint32(t, runtime, 4); length != 5 {
+		t.Fatalf("Unexpected name length: %d", length)
+	}
+	wasiCall(t, runtime, errnoSuccess, "fd_prestat_dir_name", 3, 16, 5)
+	if name, err := mem.ReadString(16, 5); err != nil || name != "/data" {
+		t.Fatalf("Unexpected name: %q, %v", name, err)
+	}
+	wasiCall(t, runtime, errnoBadf, "fd_prestat_get", 4, 0)
+
+	fd := openFile(t, runtime, errnoSuccess, 3, "sub/../hello.txt", 0, rightFDRead)
+	// One iovec at 0 pointing to 8 bytes at 64
+	mem.WriteUint32(0, 64)
+	mem.WriteUint32(4, 8)
+	wasiCall(t, runtime, errnoSuccess, "fd_read", fd, 0, 1, 16)
+	if n := readUint32(t, runtime, 16); n != 5 {
+		t.Fatalf("Unexpected read size: %d", n)
+	}
+	if s, err := mem.ReadString(64, 5); err != nil || s != "hello" {
+		t.Fatalf("Unexpected data: %q, %v", s, err)
+	}
+	wasiCall(t, runtime, errnoBadf, "fd_write", fd, 0, 1, 16)
+	wasiCall(t, runtime, errnoSuccess, "fd_seek", fd, int64(1), 0, 24)
+	if pos, err := mem.ReadUint64(24); err != nil || pos != 1 {
+		t.Fatalf("Unexpected offset: %d, %v", pos, err)
+	}
+	wasiCall(t, runtime, errnoSuccess, "fd_filestat_get", fd, 128)
+	if size, err := mem.ReadUint64(128 + 32); err != nil || size != 5 {
+		t.Fatalf("Unexpected size: %d, %v", size, err)
+	}
+	// Renumbering an fd to itself keeps it, preopens can't be replaced
+	wasiCall(t, runtime, errnoSuccess, "fd_renumber", fd, fd)
+	wasiCall(t, runtime, errnoNotsup, "fd_renumber", fd, 3)
+	wasiCall(t, runtime, errnoSuccess, "fd_close", fd)
+	wasiCall(t, runtime, errnoBadf, "fd_close", fd)
+
+	out := openFile(t, runtime, errnoSuccess, 3, "sub/out.txt", oflagCreat|oflagTrunc, rightFDWrite)
+	mem.Write(64, []byte("written"))
+	mem.WriteUint32(4, 7)
+	wasiCall(t, runtime, errnoSuccess, "fd_write", out, 0, 1, 16)
+	wasiCall(t, runtime, errnoSuccess, "fd_close", out)
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "sub", "out.txt")); err != nil || string(data) != "written" {
+		t.Fatalf("Unexpected file contents: %q, %v", data, err)
+	}
+
+	openFile(t, runtime, errnoNotcapable, 3, "../hello.txt", 0, rightFDRead)
+	openFile(t, runtime, errnoNotcapable, 3, "/etc/hostname", 0, rightFDRead)
+	openFile(t, runtime, errnoNotcapable, 3, "escape/etc/hostname", 0, rightFDRead)
+	openFile(t, runtime, errnoNoent, 3, "missing.txt", 0, rightFDRead)
+	openFile(t, runtime, errnoNotdir, 3, "hello.txt", oflagDirectory, rightFDRead)
+
+	sub := openFile(t, runtime, errnoSuccess, 3, "sub", oflagDirectory, rightFDRead)
+	openFile(t, runtime, errnoNotcapable, sub, "../../etc/hostname", 0, rightFDRead)
+	wasiCall(t, runtime, errnoSuccess, "fd_readdir", 3, 256, 512, int64(0), 16)
+	used := readUint32(t, runtime, 16)
+	var names []string
+	for offset := uint32(0); offset < used; {
+		length := readUint32(t, runtime, 256+offset+16)
+		name, err := mem.ReadString(256+offset+direntSize, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		offset += direntSize + length
+	}
+	if len(names) != 3 || names[0] != "escape" || names[1] != "hello.txt" || names[2] != "sub" {
+		t.Fatalf("Unexpected entries: %v", names)
+	}
+
+	mem.Write(1024, []byte("newdir"))
+	wasiCall(t, runtime, errnoSuccess, "path_create_directory", 3, 1024, 6)
+	if fi, err := os.Stat(filepath.Join(dir, "newdir")); err != nil || !fi.IsDir() {
+		t.Fatalf("The directory wasn't created: %v", err)
+	}
+	mem.Write(1024, []byte("hello.txt"))
+	wasiCall(t, runtime, errnoSuccess, "path_unlink_file", 3, 1024, 9)
+	if _, err := os.Stat(filepath.Join(dir, "hello.txt")); !os.IsNotExist(err) {
+		t.Fatalf("The file wasn't removed: %v", err)
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	root, err := ioutil.TempDir("", "wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	if err := ioutil.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"dangling": filepath.Join(outside, "pwned"),
+		"missing":  "missing.txt",
+		"alias":    "hello.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := DirFS(root)
+
+	// Dangling symlinks are rejected, even if they point inside of the directory
+	for _, name := range []string{"dangling", "missing"} {
+		if _, err := fsys.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644); !errors.Is(err, errPathEscapes) {
+			t.Fatalf("Expected %s to be rejected, got %v", name, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+		t.Fatalf("The file was created outside of the directory: %v", err)
+	}
+	// Symlinks inside of the directory are followed, and removed without following them
+	if data, err := fs.ReadFile(fsys, "alias"); err != nil || string(data) != "hello" {
+		t.Fatalf("Unexpected contents: %q, %v", data, err)
+	}
+	if err := fsys.Remove("alias"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "hello.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime := loadWASITestModule(t, &WASIConfig{Preopens: map[string]string{"/": root}})
+	defer runtime.Destroy()
+	openFile(t, runtime, errnoNotcapable, 3, "dangling", oflagCreat, rightFDWrite)
+	openFile(t, runtime, errnoSuccess, 3, "hello.txt", 0, rightFDRead)
+}
+
+func TestWASIReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runtime := loadWASITestModule(t, &WASIConfig{
+		Preopens: map[string]string{"/": dir},
+		ReadOnly: true,
+	})
+	defer runtime.Destroy()
+
+	fd := openFile(t, runtime, errnoSuccess, 3, "hello.txt", 0, rightFDRead)
+	wasiCall(t, runtime, errnoSuccess, "fd_close", fd)
+	openFile(t, runtime, errnoRofs, 3, "hello.txt", 0, rightFDRead|rightFDWrite)
+	openFile(t, runtime, errnoRofs, 3, "new.txt", oflagCreat, rightFDRead)
+	runtime.Memory().Write(1024, []byte("hello.txt"))
+	wasiCall(t, runtime, errnoRofs, "path_unlink_file", 3, 1024, 9)
+	wasiCall(t, runtime, errnoRofs, "path_create_directory", 3, 1024, 3)
+	if _, err := os.Stat(filepath.Join(dir, "hello.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWASIMounts(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.json":   {Data: []byte(`{"debug":true}`)},
+		"lib/module.js": {Data: []byte("export {}")},
+	}
+	runtime := loadWASITestModule(t, &WASIConfig{
+		Preopens: map[string]string{"/assets": "/"},
+		Mounts:   map[string]fs.FS{"/assets": fsys},
+	})
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	wasiCall(t, runtime, errnoSuccess, "fd_prestat_dir_name", 3, 16, 7)
+	if name, err := mem.ReadString(16, 7); err != nil || name != "/assets" {
+		t.Fatalf("Unexpected name: %q, %v", name, err)
+	}
+	wasiCall(t, runtime, errnoBadf, "fd_prestat_get", 4, 0)
+
+	lib := openFile(t, runtime, errnoSuccess, 3, "lib", oflagDirectory, rightFDRead)
+	fd := openFile(t, runtime, errnoSuccess, lib, "../config.json", 0, rightFDRead)
+	// One iovec at 0 pointing to 32 bytes at 64
+	mem.WriteUint32(0, 64)
+	mem.WriteUint32(4, 32)
+	wasiCall(t, runtime, errnoSuccess, "fd_read", fd, 0, 1, 16)
+	if n := readUint32(t, runtime, 16); n != 14 {
+		t.Fatalf("Unexpected read size: %d", n)
+	}
+	if s, err := mem.ReadString(64, 14); err != nil || s != `{"debug":true}` {
+		t.Fatalf("Unexpected data: %q, %v", s, err)
+	}
+	wasiCall(t, runtime, errnoSuccess, "fd_seek", fd, int64(-4), 2, 24)
+	if pos, err := mem.ReadUint64(24); err != nil || pos != 10 {
+		t.Fatalf("Unexpected offset: %d, %v", pos, err)
+	}
+	wasiCall(t, runtime, errnoSuccess, "fd_close", fd)
+
+	wasiCall(t, runtime, errnoSuccess, "fd_readdir", 3, 256, 512, int64(0), 16)
+	used := readUint32(t, runtime, 16)
+	if length := readUint32(t, runtime, 256+16); used != 2*direntSize+11+3 || length != 11 {
+		t.Fatalf("Unexpected entries: used=%d, first name length=%d", used, length)
+	}
+
+	openFile(t, runtime, errnoNotcapable, lib, "../../etc/hostname", 0, rightFDRead)
+	openFile(t, runtime, errnoNoent, 3, "missing.txt", 0, rightFDRead)
+	openFile(t, runtime, errnoRofs, 3, "config.json", 0, rightFDRead|rightFDWrite)
+	openFile(t, runtime, errnoRofs, 3, "new.txt", oflagCreat, rightFDWrite)
+	mem.Write(1024, []byte("config.json"))
+	wasiCall(t, runtime, errnoRofs, "path_unlink_file", 3, 1024, 11)
+}
+
+func TestWASIStdio(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	cfg := &WASIConfig{
+		Stdin:  strings.NewReader("input"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	runtime := loadWASITestModule(t, cfg)
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	// One iovec at 0 pointing to 16 bytes at 64
+	mem.WriteUint32(0, 64)
+	mem.WriteUint32(4, 16)
+	wasiCall(t, runtime, errnoSuccess, "fd_read", 0, 0, 1, 8)
+	if n := readUint32(t, runtime, 8); n != 5 {
+		t.Fatalf("Unexpected read size: %d", n)
+	}
+	wasiCall(t, runtime, errnoSuccess, "fd_read", 0, 0, 1, 8)
+	if n := readUint32(t, runtime, 8); n != 0 {
+		t.Fatalf("Expected EOF, read %d bytes", n)
+	}
+
+	mem.Write(64, []byte("out"))
+	mem.WriteUint32(4, 3)
+	wasiCall(t, runtime, errnoSuccess, "fd_write", 1, 0, 1, 8)
+	mem.Write(64, []byte("err"))
+	wasiCall(t, runtime, errnoSuccess, "fd_write", 2, 0, 1, 8)
+	if stdout.String() != "out" || stderr.String() != "err" {
+		t.Fatalf("Unexpected output: %q, %q", stdout.String(), stderr.String())
+	}
+	wasiCall(t, runtime, errnoSpipe, "fd_seek", 1, int64(0), 0, 8)
+
+	// The streams can be swapped between calls, nil discards the output
+	var captured bytes.Buffer
+	cfg.Stdout = &captured
+	wasiCall(t, runtime, errnoSuccess, "fd_write", 1, 0, 1, 8)
+	cfg.Stderr = nil
+	wasiCall(t, runtime, errnoSuccess, "fd_write", 2, 0, 1, 8)
+	if captured.String() != "err" || stdout.String() != "out" || stderr.String() != "err" {
+		t.Fatalf("Unexpected output: %q, %q, %q", captured.String(), stdout.String(), stderr.String())
+	}
+}
+
+// deterministicRun reads both clocks, sleeps 1s with poll_oneoff and reads random bytes
+func deterministicRun(t *testing.T) []byte {
+	start := time.Date(2020, 1, 15, 9, 51, 24, 0, time.UTC)
+	runtime := loadWASITestModule(t, &WASIConfig{
+		Clock: NewFakeClock(start, time.Millisecond),
+		Rand:  rand.New(rand.NewSource(42)),
+	})
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	wasiCall(t, runtime, errnoSuccess, "clock_time_get", clockRealtime, int64(0), 0)
+	if now, err := mem.ReadUint64(0); err != nil || now != uint64(start.UnixNano()) {
+		t.Fatalf("Unexpected time: %d, %v", now, err)
+	}
+	wasiCall(t, runtime, errnoSuccess, "clock_time_get", clockMonotonic, int64(0), 8)
+	if elapsed, err := mem.ReadUint64(8); err != nil || elapsed != uint64(time.Millisecond) {
+		t.Fatalf("Unexpected monotonic time: %d, %v", elapsed, err)
+	}
+	wasiCall(t, runtime, errnoInval, "clock_res_get", 9, 0)
+
+	// Two relative timeouts at 64, only the earliest fires
+	for i, timeout := range []time.Duration{time.Second, time.Hour} {
+		sub := make([]byte, subscriptionSize)
+		binary.LittleEndian.PutUint64(sub, uint64(i+1))
+		sub[8] = eventtypeClock
+		binary.LittleEndian.PutUint32(sub[16:], clockMonotonic)
+		binary.LittleEndian.PutUint64(sub[24:], uint64(timeout))
+		mem.Write(64+uint32(i)*subscriptionSize, sub)
+	}
+	wasiCall(t, runtime, errnoSuccess, "poll_oneoff", 64, 256, 2, 16)
+	if n := readUint32(t, runtime, 16); n != 1 {
+		t.Fatalf("Unexpected number of events: %d", n)
+	}
+	if userdata, err := mem.ReadUint64(256); err != nil || userdata != 1 {
+		t.Fatalf("Unexpected event: %d, %v", userdata, err)
+	}
+	wasiCall(t, runtime, errnoSuccess, "clock_time_get", clockMonotonic, int64(0), 8)
+	if elapsed, err := mem.ReadUint64(8); err != nil || elapsed != uint64(time.Second+2*time.Millisecond) {
+		t.Fatalf("Unexpected monotonic time after sleeping: %d, %v", elapsed, err)
+	}
+	wasiCall(t, runtime, errnoInval, "poll_oneoff", 64, 256, 0, 16)
+
+	wasiCall(t, runtime, errnoSuccess, "random_get", 512, 32)
+	out, err := mem.Read(0, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestWASIDeterministic(t *testing.T) {
+	first, second := deterministicRun(t), deterministicRun(t)
+	if !bytes.Equal(first, second) {
+		t.Fatal("Runs with the same clock and seed should be identical")
+	}
+	if bytes.Equal(first[512:544], make([]byte, 32)) {
+		t.Fatal("random_get didn't fill the buffer")
+	}
+}
+
+func TestWASIPollInterrupt(t *testing.T) {
+	if !execHooksSupported {
+		t.Skip("Interrupting calls isn't supported on this platform")
+	}
+	runtime := loadWASITestModule(t, &WASIConfig{})
+	defer runtime.Destroy()
+	sub := make([]byte, subscriptionSize)
+	sub[8] = eventtypeClock
+	binary.LittleEndian.PutUint32(sub[16:], clockMonotonic)
+	binary.LittleEndian.PutUint64(sub[24:], uint64(time.Hour))
+	runtime.Memory().Write(64, sub)
+	fn, err := runtime.FindFunction("poll_oneoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = fn.CallContext(ctx, 64, 256, 1, 16)
+	if !errors.Is(err, ErrInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected an interrupted error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("The sleep wasn't interrupted, it took %s", elapsed)
+	}
+}
+
+// TestWASIUnstable checks the wasi_unstable ABI differences
+func TestWASIUnstable(t *testing.T) {
+	runtime := loadWASIModule(t, &WASIConfig{
+		Args:   []string{"prog"},
+		Mounts: map[string]fs.FS{"/": fstest.MapFS{"data.txt": {Data: []byte("0123456789")}}},
+		Clock:  NewFakeClock(time.Unix(0, 0), 0),
+	}, wasiUnstableModuleName)
+	defer runtime.Destroy()
+	mem := runtime.Memory()
+
+	wasiCall(t, runtime, errnoSuccess, "args_sizes_get", 0, 4)
+	if argc := readUint32(t, runtime, 0); argc != 1 {
+		t.Fatalf("Unexpected argc: %d", argc)
+	}
+
+	// The whence values are CUR, END and SET
+	fd := openFile(t, runtime, errnoSuccess, 3, "data.txt", 0, rightFDRead)
+	for _, seek := range []struct {
+		offset   int64
+		whence   uint32
+		expected uint64
+	}{{-4, 1, 6}, {1, 0, 7}, {2, 2, 2}} {
+		wasiCall(t, runtime, errnoSuccess, "fd_seek", fd, seek.offset, seek.whence, 24)
+		if pos, err := mem.ReadUint64(24); err != nil || pos != seek.expected {
+			t.Fatalf("Unexpected offset for whence %d: %d, %v", seek.whence, pos, err)
+		}
+	}
+	wasiCall(t, runtime, errnoInval, "fd_seek", fd, int64(0), 3, 24)
+
+	// nlink is a u32 at 20 and the size follows at 24
+	mem.Write(128, bytes.Repeat([]byte{0xff}, filestatSize))
+	wasiCall(t, runtime, errnoSuccess, "fd_filestat_get", fd, 128)
+	filetype, _ := mem.ReadUint8(128 + 16)
+	nlink, _ := mem.ReadUint32(128 + 20)
+	size, _ := mem.ReadUint64(128 + 24)
+	if filetype != filetypeRegularFile || nlink != 1 || size != 10 {
+		t.Fatalf("Unexpected filestat: filetype=%d, nlink=%d, size=%d", filetype, nlink, size)
+	}
+	if end, _ := mem.ReadUint64(128 + unstableFilestatSize); end != ^uint64(0) {
+		t.Fatal("fd_filestat_get wrote past the filestat")
+	}
+
+	// The clock subscriptions start with an identifier
+	sub := make([]byte, unstableSubscriptionSize)
+	binary.LittleEndian.PutUint64(sub, 42)
+	sub[8] = eventtypeClock
+	binary.LittleEndian.PutUint64(sub[16:], 7)
+	binary.LittleEndian.PutUint32(sub[24:], clockMonotonic)
+	binary.LittleEndian.PutUint64(sub[32:], uint64(time.Second))
+	mem.Write(512, sub)
+	wasiCall(t, runtime, errnoSuccess, "poll_oneoff", 512, 640, 1, 16)
+	if n, userdata := readUint32(t, runtime, 16), readUint32(t, runtime, 640); n != 1 || userdata != 42 {
+		t.Fatalf("Unexpected events: n=%d, userdata=%d", n, userdata)
+	}
+	wasiCall(t, runtime, errnoSuccess, "clock_time_get", clockMonotonic, int64(0), 8)
+	if elapsed, err := mem.ReadUint64(8); err != nil || elapsed != uint64(time.Second) {
+		t.Fatalf("Unexpected monotonic time after sleeping: %d, %v", elapsed, err)
+	}
+
+	fn, err := runtime.FindFunction("proc_exit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *ExitError
+	if _, err := fn.Call(5); !errors.As(err, &exitErr) || exitErr.Code != 5 {
+		t.Fatalf("Expected an *ExitError, got %v", err)
+	}
+}
+
+// TestWASIUnstableStdio runs a command built for wasi_unstable, its diagnostics go to the configured Stderr
+func TestWASIUnstableStdio(t *testing.T) {
+	m := &testModule{memory: &testMemory{min: 1}}
+	fdWrite := m.addImport(testImport{module: wasiUnstableModuleName, field: "fd_write",
+		params: []byte{i32, i32, i32, i32}, results: []byte{i32}})
+	m.addFunc(testFunc{export: "_start", code: []byte{
+		// "warn" at 16 and one iovec at 0 pointing to it
+		0x41, 16, 0x41, 0xf7, 0xc2, 0xc9, 0xf3, 0x06, 0x36, 2, 0,
+		0x41, 0, 0x41, 16, 0x36, 2, 0,
+		0x41, 4, 0x41, 4, 0x36, 2, 0,
+		// fd_write(2, 0, 1, 8)
+		0x41, 2, 0x41, 0, 0x41, 1, 0x41, 8, 0x10, byte(fdWrite), 0x1a,
+	}})
+	var stdout, stderr bytes.Buffer
+	cfg := &Config{StrictImports: true, WASI: &WASIConfig{Stdout: &stdout, Stderr: &stderr}}
+	if code, err := runMain(t, cfg, m); err != nil || code != 0 {
+		t.Fatalf("Expected exit status 0, got %d, %v", code, err)
+	}
+	if stderr.String() != "warn" || stdout.Len() != 0 {
+		t.Fatalf("Unexpected output: %q, %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestWASIProcExit(t *testing.T) {
+	runtime := loadWASITestModule(t, &WASIConfig{})
+	defer runtime.Destroy()
+	fn, err := runtime.FindFunction("proc_exit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fn.Call(3)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("Expected an *ExitError, got %v", err)
+	}
+	if !errors.Is(err, ErrTrapExit) {
+		t.Fatalf("Expected ErrTrapExit, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package wasm3
+
+import(
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// wasiUnstableModuleName is the import module of the WASI version before wasi_snapshot_preview1,
+// the one of the C implementation
+const wasiUnstableModuleName = "wasi_unstable"
+
+// wasi_unstable layouts
+const(
+	// unstableFilestatSize is the size of a filestat, nlink is a u32
+	unstableFilestatSize = 56
+	// unstableSubscriptionSize is the size of a subscription, the clocks have an identifier first
+	unstableSubscriptionSize = 56
+)
+
+// unstableWhence maps the wasi_unstable whence values (CUR, END, SET) to the io ones
+var unstableWhence = [...]uint32{io.SeekCurrent, io.SeekEnd, io.SeekStart}
+
+// unstableFunctions returns the wasi_unstable functions, they only differ from the
+// wasi_snapshot_preview1 ones in the fd_seek whence values and the filestat and
+// subscription layouts
+func(w *wasiState) unstableFunctions() map[string]interface{} {
+	functions := w.functions()
+	functions["fd_seek"] = w.fdSeekUnstable
+	functions["fd_filestat_get"] = w.fdFilestatGetUnstable
+	functions["path_filestat_get"] = w.pathFilestatGetUnstable
+	functions["poll_oneoff"] = w.pollOneoffUnstable
+	return functions
+}
+
+func(w *wasiState) fdSeekUnstable(ctx *HostContext, fd uint32, offset int64, whence, newOffsetPtr uint32) wasiErrno {
+	if whence >= uint32(len(unstableWhence)) {
+		return errnoInval
+	}
+	return w.fdSeek(ctx, fd, offset, unstableWhence[whence], newOffsetPtr)
+}
+
+// unstableFilestat converts a filestat to the wasi_unstable layout
+func unstableFilestat(stat []byte) []byte {
+	buf := make([]byte, unstableFilestatSize)
+	copy(buf, stat[:17])
+	binary.LittleEndian.PutUint32(buf[20:], uint32(binary.LittleEndian.Uint64(stat[24:])))
+	copy(buf[24:], stat[32:])
+	return buf
+}
+
+func(w *wasiState) fdFilestatGetUnstable(ctx *HostContext, fd, buf uint32) wasiErrno {
+	stat, errno := w.fdFilestat(fd)
+	if errno != errnoSuccess {
+		return errno
+	}
+	mem := memoryOf(ctx)
+	mem.put(buf, unstableFilestat(stat))
+	return mem.errno()
+}
+
+func(w *wasiState) pathFilestatGetUnstable(ctx *HostContext, fd, flags, pathPtr, pathLen, buf uint32) wasiErrno {
+	stat, errno := w.pathFilestat(ctx, fd, pathPtr, pathLen)
+	if errno != errnoSuccess {
+		return errno
+	}
+	mem := memoryOf(ctx)
+	mem.put(buf, unstableFilestat(stat))
+	return mem.errno()
+}
+
+// pollOneoffUnstable converts the subscriptions to the wasi_snapshot_preview1 layout, the
+// events are the same
+func(w *wasiState) pollOneoffUnstable(ctx *HostContext, in, out, n, neventsPtr uint32) (wasiErrno, error) {
+	if n == 0 {
+		return errnoInval, nil
+	}
+	if n > math.MaxUint32 / unstableSubscriptionSize {
+		return errnoFault, nil
+	}
+	unstable, err := ctx.Memory().Read(in, n * unstableSubscriptionSize)
+	if err != nil {
+		return errnoFault, nil
+	}
+	subs := make([]byte, n * subscriptionSize)
+	for i := uint32(0); i < n; i++ {
+		sub := subs[i * subscriptionSize:(i + 1) * subscriptionSize]
+		src := unstable[i * unstableSubscriptionSize:(i + 1) * unstableSubscriptionSize]
+		copy(sub, src[:16])
+		if src[8] == eventtypeClock {
+			// Skip the identifier, the userdata identifies the events already
+			copy(sub[16:], src[24:])
+		} else {
+			copy(sub[16:], src[16:])
+		}
+	}
+	return w.poll(ctx, subs, out, neventsPtr)
+}
@@ -1,6 +1,7 @@
 package wasm3
 
 /*
+#cgo CFLAGS: -Dd_m3MaxNumFunctionArgs=32
 #cgo darwin CFLAGS: -Iinclude
 #cgo darwin LDFLAGS: -L${SRCDIR}/lib/darwin -lm3
 #cgo linux CFLAGS: -Iinclude
@@ -18,48 +19,85 @@ IM3Function module_get_function(IM3Module i_module, int index) {
 	return f;
 }
 
-int call(IM3Function i_function, uint32_t i_argc, int i_argv[]) {
-	int result = 0;
+__thread go_call_ctrl* go_current_ctrl = NULL;
+
+M3Result call(IM3Function i_function, go_call_ctrl* i_ctrl, uint32_t i_argc, uint64_t i_argv[], uint64_t* o_result) {
 	IM3Module module = i_function->module;
 	IM3Runtime runtime = module->runtime;
 	m3stack_t stack = (m3stack_t)(runtime->stack);
-	IM3FuncType ftype = i_function->funcType;
-	for (int i = 0; i < ftype->numArgs; i++) {
-		int v = i_argv[i];
-		m3stack_t s = &stack[i];
-		*(u32*)(s) = v;
+	for (int i = 0; i < i_argc; i++) {
+		stack[i] = i_argv[i];
 	}
+	m3_ResetErrorInfo(runtime);
 	m3StackCheckInit();
+	// Host functions may call other runtimes, the previous control block is restored afterwards
+	go_call_ctrl* prev_ctrl = go_current_ctrl;
+	go_current_ctrl = i_ctrl;
 	M3Result call_result = Call(i_function->compiled, stack, runtime->memory.mallocated, d_m3OpDefaultArgs);
+	go_current_ctrl = prev_ctrl;
 	if(call_result != NULL) {
-		set_error(call_result);
-		return -1;
+		return call_result;
 	}
-	switch (ftype->returnType) {
-		case c_m3Type_i32:
-			result = *(u32*)(stack);
-			break;
-		case c_m3Type_i64:
-		default:
-			result =  *(u32*)(stack);
-	};
-	return result;
+	*o_result = stack[0];
+	return m3Err_none;
 }
 
 int get_allocated_memory_length(IM3Runtime i_runtime) {
+	if (i_runtime->memory.mallocated == NULL) {
+		return 0;
+	}
 	return i_runtime->memory.mallocated->length;
 }
 
 u8* get_allocated_memory(IM3Runtime i_runtime) {
+	if (i_runtime->memory.mallocated == NULL) {
+		return NULL;
+	}
 	return m3MemData(i_runtime->memory.mallocated);
 }
+
+M3Result goErr_hostFunction = "[trap] host function returned an error";
+M3Result goErr_interrupted = "[trap] execution interrupted";
+M3Result goErr_outOfFuel = "[trap] out of fuel";
+
+// op_CallGoFunction is the body of the imported functions implemented in Go,
+// the immediate holds the host function ID.
+m3ret_t vectorcall op_CallGoFunction(d_m3OpSig) {
+	uint64_t id = (uint64_t) immediate(uintptr_t);
+	return go_host_call(id, _sp);
+}
+
+// get_go_function_id returns the host function ID of a function linked with link_go_function, 0 otherwise.
+uint64_t get_go_function_id(IM3Function i_function) {
+	pc_t pc = i_function->compiled;
+	if (pc == NULL || pc[0] != (void*) op_CallGoFunction) {
+		return 0;
+	}
+	return (uint64_t) (uintptr_t) pc[1];
+}
+
+// link_go_function works like LinkRawFunction but emits op_CallGoFunction.
+M3Result link_go_function(IM3Module i_module, IM3Function i_function, uint64_t i_id) {
+	IM3Runtime runtime = i_module->runtime;
+	IM3CodePage page = AcquireCodePageWithCapacity(runtime, 2);
+	if (!page) {
+		return m3Err_mallocFailedCodePage;
+	}
+	i_function->compiled = GetPagePC(page);
+	i_function->module = i_module;
+	EmitWord(page, op_CallGoFunction);
+	EmitWord(page, (uintptr_t) i_id);
+	ReleaseCodePage(runtime, page);
+	return m3Err_none;
+}
 */
 import "C"
 
 import(
 	"unsafe"
-	"errors"
+	"fmt"
 	"reflect"
+	"crypto/sha256"
 )
 
 // RuntimeT is an alias for IM3Runtime
@@ -73,23 +111,54 @@ type FunctionT C.IM3Function
 // ResultT is an alias for M3Result
 type ResultT C.M3Result
 
-var(
-	errParseModule = errors.New("Parse error")
-	errLoadModule = errors.New("Load error")
-	errFuncLookupFailed = errors.New("Function lookup failed")
-)
 
 // Config holds the runtime and environment configuration
 type Config struct {
 	Environment *Environment
 	StackSize uint
+	// EnableWASI links the C implementation of WASI (wasi_unstable), it uses the host
+	// environment, filesystem and standard streams, the arguments are set by RunMain
 	EnableWASI bool
+	// WASI links the Go implementation of WASI (wasi_snapshot_preview1 and wasi_unstable), see
+	// WASIConfig. It replaces the C functions when EnableWASI is set too.
+	WASI *WASIConfig
+	// Fuel is the budget of the calls made with Call and CallContext, 0 disables metering.
+	// Function entries and loop iterations are charged the number of ops of their block, see CallWithFuel
+	Fuel uint64
+	// StrictImports makes LoadModule fail with a *LinkError if any function import
+	// wouldn't be linked (spec test, WASI and RegisterHostFunc functions). It's checked
+	// before loading, the module can be loaded once the imports are registered.
+	StrictImports bool
+	// MaxMemoryPages caps the guest memory in 64KiB pages, even when the module doesn't
+	// declare a maximum. 0 leaves the module limit (or the 4GiB WASM limit).
+	MaxMemoryPages uint32
 }
 
 // Runtime wraps a WASM3 runtime
 type Runtime struct {
 	ptr RuntimeT
 	cfg *Config
+	// hostFunctions holds the IDs of the host functions linked into this runtime
+	hostFunctions []uint64
+	// hostErr is the last error returned by a host function
+	hostErr error
+	// hostFuncs holds the functions registered with RegisterHostFunc
+	hostFuncs []*registeredHostFunc
+	modules []*Module
+	// ctrl is the control block passed to the calls
+	ctrl *C.go_call_ctrl
+	fuelConsumed uint64
+	// allocator is set by UseAllocator
+	allocator *allocator
+	// globals holds the global imports defined with DefineGlobal
+	globals []*definedGlobal
+	// wasi is set when Config.WASI is used
+	wasi *wasiState
+	// argv holds the C strings passed to the C WASI by RunMain
+	argv []*C.char
+	// interrupt is closed when the current CallContext call is interrupted, so blocking
+	// host functions can return
+	interrupt chan struct{}
 }
 
 // Ptr returns a IM3Runtime pointer
@@ -98,101 +167,121 @@ func(r *Runtime) Ptr() C.IM3Runtime {
 }
 
 // Load wraps the parse and load module calls.
-// This will be replaced by env.ParseModule and Runtime.LoadModule.
 func(r *Runtime) Load(wasmBytes []byte) (*Module, error) {
-	result := C.m3Err_none
-	bytes := C.CBytes(wasmBytes)
-	length := len(wasmBytes)
-	var module C.IM3Module
-	result = C.m3_ParseModule(
-		r.cfg.Environment.Ptr(),
-		&module,
-		(*C.uchar)(bytes),
-		C.uint(length),
-	)
-	if result != nil {
-		return nil, errParseModule
-	}
-	result = C.m3_LoadModule(
-		r.Ptr(),
-		module,
-	)
-	if result != nil {
-		return nil, errLoadModule
-	}
-	result = C.m3_LinkSpecTest(r.Ptr().modules)
-	if result != nil {
-		return nil, errors.New("LinkSpecTest failed")
+	module, err := r.ParseModule(wasmBytes)
+	if err != nil {
+		return nil, err
 	}
-	if r.cfg.EnableWASI {
-		C.m3_LinkWASI(r.Ptr().modules)
-	}
-	m := NewModule((ModuleT)(module))
-	return m, nil
+	return r.LoadModule(module)
 }
 
 // LoadModule wraps m3_LoadModule and returns a module object
 func(r *Runtime) LoadModule(module *Module) (*Module, error) {
+	if err := r.limitMemory(module); err != nil {
+		return nil, err
+	}
+	missingGlobals, err := r.linkGlobals(module)
+	if err != nil {
+		return nil, err
+	}
+	if r.cfg.StrictImports {
+		missing := r.unresolvedImports(module)
+		if len(missing) > 0 || len(missingGlobals) > 0 {
+			err := ErrFunctionImportMissing
+			if len(missing) == 0 {
+				err = ErrGlobalImportMissing
+			}
+			return nil, &LinkError{Err: err, Missing: append(missing, missingGlobals...)}
+		}
+	}
 	result := C.m3Err_none
+	C.m3_ResetErrorInfo(r.Ptr())
 	result = C.m3_LoadModule(
 		r.Ptr(),
 		module.Ptr(),
 	)
 	if result != nil {
-		return nil, errLoadModule
+		return nil, r.newError(result, kindCompile, module.Ptr(), nil)
 	}
 	result = C.m3_LinkSpecTest(r.Ptr().modules)
 	if result != nil {
-		return nil, errors.New("LinkSpecTest failed")
+		return nil, r.newError(result, kindLink, module.Ptr(), nil)
 	}
 	if r.cfg.EnableWASI {
 		C.m3_LinkWASI(r.Ptr().modules)
 	}
+	module.runtime = r
+	for _, hf := range r.hostFuncs {
+		if err := hf.link(module); err != nil {
+			return nil, err
+		}
+	}
+	r.modules = append(r.modules, module)
 	return module, nil
 }
 
-// FindFunction calls m3_FindFunction and returns a call function
-func(r *Runtime) FindFunction(funcName string) (FunctionWrapper, error) {
+// FindFunction calls m3_FindFunction and returns the function
+func(r *Runtime) FindFunction(funcName string) (*Function, error) {
 	result := C.m3Err_none
 	var f C.IM3Function
 	cFuncName := C.CString(funcName)
 	defer C.free(unsafe.Pointer(cFuncName))
+	C.m3_ResetErrorInfo(r.Ptr())
 	result = C.m3_FindFunction(
 		&f,
 		r.Ptr(),
 		cFuncName,
 	)
 	if result != nil {
-		return nil, errFuncLookupFailed
+		err := r.newError(result, kindLink, nil, f)
+		if e := err.(*Error); e.Function == "" {
+			e.Function = funcName
+		}
+		return nil, err
 	}
 	fn := &Function{
 		ptr: (FunctionT)(f),
+		Name: funcName,
+		runtime: r,
 	}
-	// var fnWrapper FunctionWrapper
-	// fnWrapper = fn.Call
-	return FunctionWrapper(fn.Call), nil
+	return fn, nil
 }
 
 // Destroy free calls m3_FreeRuntime
 func(r *Runtime) Destroy() {
+	r.freeArgs()
 	C.m3_FreeRuntime(r.Ptr());
+	C.free(unsafe.Pointer(r.ctrl))
+	unregisterHostFunctions(r.hostFunctions)
+	if r.wasi != nil {
+		r.wasi.close()
+	}
 	r.cfg.Environment.Destroy()
 }
 
-// Memory allows access to runtime Memory.
+// Memory returns a bounds-checked accessor for the runtime memory, it remains valid when the memory grows
+func(r *Runtime) Memory() *Memory {
+	return &Memory{
+		runtime: r,
+	}
+}
+
+// memoryBytes returns the runtime memory as a slice, it isn't valid after the memory grows.
 // Taken from Wasmer extension: https://github.com/wasmerio/go-ext-wasm
-func(r *Runtime) Memory() []byte {
+func(r *Runtime) memoryBytes() []byte {
 	mem := C.get_allocated_memory(
 		r.Ptr(),
 	)
-	var data = (*uint8)(mem)
+	var data []byte
 	length := r.GetAllocatedMemoryLength()
-	var header reflect.SliceHeader
-	header = *(*reflect.SliceHeader)(unsafe.Pointer(&header))
-	header.Data = uintptr(unsafe.Pointer(data))
+	if mem == nil || length == 0 {
+		return nil
+	}
+	header := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	header.Data = uintptr(unsafe.Pointer(mem))
 	header.Len = int(length)
 	header.Cap = int(length)
-	return *(*[]byte)(unsafe.Pointer(&header))
+	return data
 }
 
 // GetAllocatedMemoryLength returns the amount of allocated runtime memory
@@ -215,16 +304,34 @@ func NewRuntime(cfg *Config) *Runtime {
 		C.uint(cfg.StackSize),
 		nil,
 	)
-	return &Runtime{
+	r := &Runtime{
 		ptr: (RuntimeT)(ptr),
 		cfg: cfg,
+		ctrl: (*C.go_call_ctrl)(C.calloc(1, C.sizeof_go_call_ctrl)),
+	}
+	r.ctrl.maxPages = C.uint32_t(cfg.MaxMemoryPages)
+	if cfg.WASI != nil {
+		r.wasi = newWASI(cfg.WASI)
+		r.wasi.register(r)
 	}
+	if cfg.EnableWASI && cfg.WASI == nil {
+		// The C proc_exit doesn't keep the exit code
+		if err := r.RegisterHostFunc(wasiUnstableModuleName, "proc_exit", procExit); err != nil {
+			panic(fmt.Sprintf("wasi proc_exit: %s", err))
+		}
+	}
+	return r
 }
 
 // Module wraps a WASM3 module.
 type Module struct {
 	ptr ModuleT
 	numFunctions int
+	runtime *Runtime
+	// sections holds the imports and exports, it's nil for modules created with NewModule
+	sections *wasmSections
+	// hash is the SHA-256 of the WASM binary, it's zero for modules created with NewModule
+	hash [sha256.Size]byte
 }
 
 // Ptr returns a pointer to IM3Module
@@ -235,13 +342,14 @@ func(m *Module) Ptr() C.IM3Module {
 // GetFunction provides access to IM3Function->functions
 func(m *Module) GetFunction(index uint) (*Function, error) {
 	if uint(m.NumFunctions()) <= index {
-		return nil, errFuncLookupFailed
+		return nil, ErrFunctionLookupFailed
 	}
 	ptr := C.module_get_function(m.Ptr(), C.int(index))
 	name := C.GoString(ptr.name)
 	return &Function{
 		ptr: (FunctionT)(ptr),
 		Name: name,
+		runtime: m.runtime,
 	}, nil
 }
 
@@ -258,10 +366,11 @@ func(m *Module) GetFunctionByName(lookupName string) (*Function, error) {
 		fn = &Function{
 			ptr: (FunctionT)(ptr),
 			Name: name,
+			runtime: m.runtime,
 		}
 		return fn, nil
 	}
-	return nil, errFuncLookupFailed
+	return nil, ErrFunctionLookupFailed
 }
 
 // NumFunctions provides access to numFunctions.
@@ -284,19 +393,20 @@ func NewModule(ptr ModuleT) *Module {
 // Function is a function wrapper
 type Function struct {
 	ptr FunctionT
-	// fnWrapper FunctionWrapper
 	Name string
+	runtime *Runtime
 }
 
-// FunctionWrapper is used to wrap WASM3 call methods and make the calls more idiomatic
-// TODO: this is very limited, we need to handle input and output types appropriately
-type FunctionWrapper func(args ...interface{}) (int, error)
-
 // Ptr returns a pointer to IM3Function
 func(f *Function) Ptr() C.IM3Function {
 	return (C.IM3Function)(f.ptr)
 }
 
+// Signature returns the parameter and result types of the function
+func(f *Function) Signature() *Signature {
+	return newSignature(f.Ptr().funcType)
+}
+
 // CallWithArgs wraps m3_CallWithArgs
 func(f *Function) CallWithArgs(args... string) {
 	length := len(args)
@@ -308,28 +418,58 @@ func(f *Function) CallWithArgs(args... string) {
 	C.m3_CallWithArgs(f.Ptr(), C.uint(length), &cArgs[0])
 }
 
-// Call implements a better call function
-// TODO: support diferent types
-func(f *Function) Call(args... interface{}) (int, error) {
-	length := len(args)
-	if length == 0 {
-		result := C.call(f.Ptr(), 0, nil)
-		if result == -1 {
-			return int(result), errors.New(LastErrorString())
-		}
-		return int(result), nil
+// Call marshals the arguments according to the function type and calls it.
+// Arguments may be any Go integer or float type that fits the parameter type,
+// the result is an int32, int64, float32 or float64 (nil for void functions).
+// The call is metered when Config.Fuel is set.
+func(f *Function) Call(args... interface{}) (interface{}, error) {
+	if f.runtime != nil && f.runtime.cfg.Fuel > 0 {
+		result, _, err := f.CallWithFuel(f.runtime.cfg.Fuel, args...)
+		return result, err
+	}
+	return f.call(args...)
+}
+
+func(f *Function) call(args... interface{}) (interface{}, error) {
+	sig := f.Signature()
+	if len(args) != sig.NumParams() {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", f.Name, sig.NumParams(), len(args))
 	}
-	cArgs := make([]C.int, length)
+	cArgs := make([]C.uint64_t, len(args) + 1)
 	for i, v := range args {
-		val := v.(int)
-		n := C.int(val)
-		cArgs[i] = n
+		slot, err := toSlot(v, sig.Params[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i, err)
+		}
+		cArgs[i] = C.uint64_t(slot)
+	}
+	var ctrl *C.go_call_ctrl
+	if f.runtime != nil {
+		f.runtime.hostErr = nil
+		ctrl = f.runtime.ctrl
+		ctrl.deniedPages = 0
 	}
-	result := C.call(f.Ptr(), C.uint(length), &cArgs[0])
-	if result == -1 {
-		return int(result), errors.New(LastErrorString())
+	var result C.uint64_t
+	if callResult := C.call(f.Ptr(), ctrl, C.uint32_t(len(args)), &cArgs[0], &result); callResult != nil {
+		if f.runtime != nil {
+			err := f.runtime.newError(callResult, kindTrap, nil, f.Ptr())
+			if hostErr := f.runtime.hostErr; hostErr != nil {
+				// The host function error is the trap, errors.Is and errors.As still reach it
+				f.runtime.hostErr = nil
+				e := err.(*Error)
+				e.Err = &TrapError{Err: hostErr}
+				e.Message = ""
+				return nil, e
+			}
+			if ctrl.deniedPages != 0 {
+				// The trap is likely caused by the guest running out of memory
+				err = &MemoryLimitError{Requested: uint32(ctrl.deniedPages), Max: f.runtime.cfg.MaxMemoryPages, Err: err}
+			}
+			return nil, err
+		}
+		return nil, newError(callResult, kindTrap)
 	}
-	return int(result), nil
+	return fromSlot(uint64(result), sig.Result), nil
 }
 
 // Environment wraps a WASM3 environment
@@ -350,9 +490,17 @@ func(e *Environment) ParseModule(wasmBytes []byte) (*Module, error) {
 		C.uint(length),
 	)
 	if result != nil {
-		return nil, errParseModule
+		return nil, newError(result, kindCompile)
+	}
+	sections, err := parseSections(wasmBytes)
+	if err != nil {
+		C.m3_FreeModule(module)
+		return nil, &CompileError{Err: err}
 	}
-	return NewModule((ModuleT)(module)), nil
+	m := NewModule((ModuleT)(module))
+	m.sections = sections
+	m.hash = sha256.Sum256(wasmBytes)
+	return m, nil
 }
 // Ptr returns a pointer to IM3Environment
 func(e *Environment) Ptr() C.IM3Environment {
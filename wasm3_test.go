@@ -88,3 +88,109 @@ func TestModuleHelpers(t *testing.T) {
 		t.Fatal("Module NumFunctions should be 1")
 	}
 }
+
+func loadTestModule(t testing.TB, m *testModule) *Runtime {
+	runtime := NewRuntime(&Config{
+		Environment: NewEnvironment(),
+		StackSize:   64 * 1024,
+	})
+	_, err := runtime.Load(m.bytes())
+	if err != nil {
+		runtime.Destroy()
+		t.Fatal(err)
+	}
+	return runtime
+}
+
+func TestCallTypes(t *testing.T) {
+	m := &testModule{}
+	m.addFunc(testFunc{export: "add64", params: []byte{i64, i64}, results: []byte{i64},
+		code: []byte{0x20, 0, 0x20, 1, 0x7c}})
+	m.addFunc(testFunc{export: "mulf64", params: []byte{f64, f64}, results: []byte{f64},
+		code: []byte{0x20, 0, 0x20, 1, 0xa2}})
+	m.addFunc(testFunc{export: "addf32", params: []byte{f32, f32}, results: []byte{f32},
+		code: []byte{0x20, 0, 0x20, 1, 0x92}})
+	m.addFunc(testFunc{export: "mixed", params: []byte{i32, i64, f32, f64}, results: []byte{f64},
+		code: []byte{0x20, 0, 0xb7, 0x20, 1, 0xb9, 0xa0, 0x20, 2, 0xbb, 0xa0, 0x20, 3, 0xa0}})
+	m.addFunc(testFunc{export: "noop"})
+	runtime := loadTestModule(t, m)
+	defer runtime.Destroy()
+
+	cases := []struct {
+		name   string
+		args   []interface{}
+		result interface{}
+	}{
+		{"add64", []interface{}{int64(1) << 40, int64(3)}, int64(1)<<40 + 3},
+		{"add64", []interface{}{uint64(1) << 63, 1}, int64(-1<<63 + 1)},
+		{"mulf64", []interface{}{1.5, 2.25}, 3.375},
+		{"addf32", []interface{}{float32(0.5), float32(0.25)}, float32(0.75)},
+		{"mixed", []interface{}{int32(-1), int64(1) << 33, float32(0.5), 0.25}, float64(1<<33) - 0.25},
+		{"mixed", []interface{}{uint32(1), 2, 3.0, float32(4)}, 10.0},
+		{"noop", nil, nil},
+	}
+	for _, c := range cases {
+		fn, err := runtime.FindFunction(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := fn.Call(c.args...)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if result != c.result {
+			t.Fatalf("%s: expected %v (%T), got %v (%T)", c.name, c.result, c.result, result, result)
+		}
+	}
+
+	fn, _ := runtime.FindFunction("add64")
+	if _, err := fn.Call(1); err == nil {
+		t.Fatal("Wrong number of arguments should error")
+	}
+	if _, err := fn.Call(1, 1.5); err == nil {
+		t.Fatal("Float argument for i64 parameter should error")
+	}
+	fn, _ = runtime.FindFunction("mixed")
+	if _, err := fn.Call(int64(1)<<32, 0, 0.0, 0.0); err == nil {
+		t.Fatal("Out of range i32 argument should error")
+	}
+}
+
+func TestFunctionSignature(t *testing.T) {
+	m := &testModule{}
+	m.addFunc(testFunc{export: "mixed", params: []byte{i32, i64, f32, f64}, results: []byte{i64},
+		code: []byte{0x20, 1}})
+	m.addFunc(testFunc{export: "noop"})
+	runtime := loadTestModule(t, m)
+	defer runtime.Destroy()
+
+	fn, err := runtime.FindFunction("mixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := fn.Signature()
+	if sig.NumParams() != 4 || sig.NumResults() != 1 {
+		t.Fatalf("Unexpected arity: %d params, %d results", sig.NumParams(), sig.NumResults())
+	}
+	expected := []ValueType{TypeI32, TypeI64, TypeF32, TypeF64}
+	for i, p := range sig.Params {
+		if p != expected[i] {
+			t.Fatalf("Parameter %d should be %s, got %s", i, expected[i], p)
+		}
+	}
+	if sig.Result != TypeI64 {
+		t.Fatalf("Result should be i64, got %s", sig.Result)
+	}
+	if sig.String() != "I(iIfF)" {
+		t.Fatalf("Unexpected signature string: %s", sig)
+	}
+
+	fn, err = runtime.FindFunction("noop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig = fn.Signature()
+	if sig.NumParams() != 0 || sig.NumResults() != 0 || sig.String() != "v()" {
+		t.Fatalf("Unexpected signature for noop: %s", sig)
+	}
+}